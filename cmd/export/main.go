@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"database/sql/driver"
 	"log/slog"
+	"slices"
 	"time"
 
 	"github.com/Pineapple217/MetaRaid/pkg/config"
@@ -73,7 +74,17 @@ func main() {
 			explicit BOOLEAN,
 			preview_url STRING,
 			type STRING,
-			 -- playable BOOLEAN,
+			playable BOOLEAN,
+			playable_markets STRING[],
+			isrc STRING,
+			upc STRING,
+			ean STRING,
+			label STRING,
+			copyright STRING,
+			phonographic_copyright STRING,
+			disc_number INTEGER,
+			track_number INTEGER,
+			available_markets INTEGER,
 
 			genres STRING[],
 			artist_follower INTEGER,
@@ -99,6 +110,24 @@ func main() {
 		return
 	}
 
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS track_analysis (
+			track_id STRING,
+			sections INTEGER,
+			segments INTEGER,
+			beats INTEGER,
+			bars INTEGER,
+			tatums INTEGER,
+			tempo_confidence FLOAT,
+			key_confidence FLOAT,
+			key_changes INTEGER,
+		)
+	`)
+	if err != nil {
+		slog.Error("failed to create table 'track_analysis'", slog.Any("error", err))
+		return
+	}
+
 	startTime := time.Now()
 	Export(conn, rdb, ctx)
 	duration := time.Since(startTime)
@@ -120,6 +149,9 @@ func Export(conn driver.Conn, rdb *redis.Client, ctx context.Context) error {
 	appenderPart, err := duckdb.NewAppenderFromConn(conn, "", "tracks_parts")
 	helper.MaybeDieErr(err)
 	defer appenderPart.Close()
+	appenderAnalysis, err := duckdb.NewAppenderFromConn(conn, "", "track_analysis")
+	helper.MaybeDieErr(err)
+	defer appenderAnalysis.Close()
 	for {
 		keys, newCursor, err := rdb.Scan(ctx, cursor, prefix+"*", batchSize).Result()
 		helper.MaybeDieErr(err)
@@ -142,6 +174,7 @@ func Export(conn driver.Conn, rdb *redis.Client, ctx context.Context) error {
 				helper.MaybeDieErr(err)
 				continue
 			}
+			album := GetAlbumMeta(record.Album)
 			err = appender.AppendRow(
 				record.Track.ID.String(),
 				record.Track.Name,
@@ -172,7 +205,17 @@ func Export(conn driver.Conn, rdb *redis.Client, ctx context.Context) error {
 				record.Track.Explicit,
 				record.Track.PreviewURL,
 				record.Track.Type,
-				// record.Track.IsPlayable,
+				GetPlayable(record.Track),
+				PlayableMarkets(record.Playable),
+				record.Track.ExternalIDs["isrc"],
+				album.ExternalIDs["upc"],
+				album.ExternalIDs["ean"],
+				album.Label,
+				album.Copyright("C"),
+				album.Copyright("P"),
+				int32(record.Track.DiscNumber),
+				int32(record.Track.TrackNumber),
+				GetAvailableMarkets(&record),
 
 				ExtractUniqueGenres(record.Artists),
 				int32(record.Artists[0].Followers.Count),
@@ -182,6 +225,20 @@ func Export(conn driver.Conn, rdb *redis.Client, ctx context.Context) error {
 				GetImage(record.Artists[0].Images, 2),
 			)
 			helper.MaybeDieErr(err)
+			if record.Analysis != nil {
+				err = appenderAnalysis.AppendRow(
+					record.Track.ID.String(),
+					int32(record.Analysis.Sections),
+					int32(record.Analysis.Segments),
+					int32(record.Analysis.Beats),
+					int32(record.Analysis.Bars),
+					int32(record.Analysis.Tatums),
+					float32(record.Analysis.TempoConfidence),
+					float32(record.Analysis.KeyConfidence),
+					int32(record.Analysis.KeyChanges),
+				)
+				helper.MaybeDieErr(err)
+			}
 		}
 		slog.Info("fetches tracks", "count", len(keys), "offset", cursor)
 
@@ -199,6 +256,45 @@ func GetImage(imgs []s.Image, i int) string {
 	return ""
 }
 
+// GetPlayable returns whether the track is playable in the primary market,
+// nil if it was scraped without a market.
+func GetPlayable(t *s.FullTrack) any {
+	if t.IsPlayable == nil {
+		return nil
+	}
+	return *t.IsPlayable
+}
+
+// GetAvailableMarkets returns the number of markets the track is available
+// in, nil if it was scraped with a market. Spotify leaves available_markets
+// out of market scoped responses, playable_markets covers those tracks.
+func GetAvailableMarkets(r *spotify.FullerTrack) any {
+	if r.Playable != nil {
+		return nil
+	}
+	return int32(len(r.Track.AvailableMarkets))
+}
+
+func PlayableMarkets(playable map[string]bool) []string {
+	markets := []string{}
+	for m, ok := range playable {
+		if ok {
+			markets = append(markets, m)
+		}
+	}
+	slices.Sort(markets)
+	return markets
+}
+
+// GetAlbumMeta returns an empty AlbumMeta for records stored before album
+// metadata was captured.
+func GetAlbumMeta(a *spotify.AlbumMeta) spotify.AlbumMeta {
+	if a == nil {
+		return spotify.AlbumMeta{}
+	}
+	return *a
+}
+
 func ExtractUniqueGenres(artists []*s.FullArtist) []string {
 	var uniqueGenres []string
 
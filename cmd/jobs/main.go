@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/Pineapple217/MetaRaid/pkg/config"
+	"github.com/Pineapple217/MetaRaid/pkg/database"
+	"github.com/Pineapple217/MetaRaid/pkg/helper"
+	"github.com/redis/go-redis/v9"
+)
+
+const usage = `Usage: jobs <command> [flags]
+
+Commands:
+  repair    bring job data in line with the current key scheme, the
+            scraper must not be running
+  verify    report jobs whose sets and hash contradict each other, -fix
+            repairs them, the scraper should not be running
+  status    show the crawl progress and the estimated time until the
+            pending queue is empty
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	conf, err := config.Load()
+	helper.MaybeDie(err, "Failed to load configs")
+
+	rdb := database.NewRedis(conf.Redis)
+	defer rdb.Close()
+
+	ctx := context.Background()
+	switch os.Args[1] {
+	case "repair":
+		repair(rdb, ctx, os.Args[2:])
+	case "verify":
+		verify(rdb, ctx, os.Args[2:])
+	case "status":
+		status(rdb, ctx, os.Args[2:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
+
+func repair(rdb *redis.Client, ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("repair", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "only report what would be changed")
+	fs.Parse(args)
+
+	r, err := database.RepairJobs(rdb, ctx, *dryRun)
+	helper.MaybeDie(err, "Failed to repair jobs")
+
+	slog.Info("Repair done",
+		"dry_run", *dryRun,
+		"prefixed_members", r.PrefixedMembers,
+		"double_prefixed", r.DoublePrefixed,
+		"finished_working", r.FinishedWorking,
+		"stuck_working", r.StuckWorking,
+		"orphans", r.Orphans,
+		"status_fixed", r.StatusFixed,
+		"total", r.Total(),
+	)
+}
+
+func verify(rdb *redis.Client, ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	fix := fs.Bool("fix", false, "fix every problem that has a safe fix")
+	requeueEmpty := fs.Bool("requeue-empty", false, "with -fix, requeue done jobs that neither stored nor skipped any tracks")
+	fs.Parse(args)
+
+	r, err := database.VerifyJobs(rdb, ctx)
+	helper.MaybeDie(err, "Failed to verify jobs")
+
+	counts := make(map[database.ProblemKind]int)
+	fixed := 0
+	for _, p := range r.Problems {
+		counts[p.Kind]++
+		slog.Warn("Inconsistent job", "job", p.Job, "kind", p.Kind, "detail", p.Detail, "fix", p.Fix)
+
+		status := p.Fix
+		if p.Kind == database.EmptyDone && *requeueEmpty {
+			status = "pending"
+		}
+		if !*fix || status == "" {
+			continue
+		}
+		err = database.FixJob(rdb, ctx, p.Job, status)
+		if err != nil {
+			slog.Error("Failed to fix job", "job", p.Job, "error", err)
+			continue
+		}
+		fixed++
+	}
+
+	slog.Info("Verify done",
+		"jobs", r.Jobs,
+		"problems", len(r.Problems),
+		"duplicate", counts[database.Duplicate],
+		"orphan", counts[database.Orphan],
+		"status_mismatch", counts[database.StatusMismatch],
+		"missing_hash", counts[database.MissingHash],
+		"empty_done", counts[database.EmptyDone],
+		"fixed", fixed,
+	)
+	if len(r.Problems) > fixed {
+		os.Exit(1)
+	}
+}
+
+func status(rdb *redis.Client, ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	window := fs.Duration("window", time.Hour, "time the rates are based on")
+	fs.Parse(args)
+
+	current, err := database.SampleProgress(rdb, ctx)
+	helper.MaybeDie(err, "Failed to read queue sizes")
+	samples, err := database.GetProgressSamples(rdb, ctx, *window)
+	helper.MaybeDie(err, "Failed to read progress")
+
+	fmt.Printf("artists   %d found, %d pending, %d working, %d done, %d failed\n",
+		current.Jobs(), current.Pending, current.Working, current.Done, current.Failed)
+	fmt.Printf("tracks    %d\n", current.Tracks)
+
+	p, ok := database.EstimateProgress(samples)
+	if !ok {
+		fmt.Println("not enough progress samples yet, the scraper takes one every minute")
+		return
+	}
+	fmt.Printf("rates     over the last %s\n", p.Window.Round(time.Minute))
+	fmt.Printf("  jobs    %.1f/min\n", p.JobsPerMinute)
+	fmt.Printf("  tracks  %.1f/min\n", p.TracksPerMinute)
+	fmt.Printf("  found   %.1f artists/min\n", p.DiscoveredPerMinute)
+	fmt.Printf("frontier  %+.1f pending/min\n", p.FrontierGrowth)
+	if p.Latest.Pending == 0 {
+		fmt.Println("eta       the pending queue is empty")
+	} else if p.ETA == 0 {
+		fmt.Println("eta       unknown, the pending queue is not shrinking")
+	} else {
+		fmt.Printf("eta       %s (%s)\n", p.ETA.Round(time.Minute), time.Now().Add(p.ETA).Format(time.DateTime))
+	}
+}
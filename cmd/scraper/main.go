@@ -1,16 +1,21 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"os"
 	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/Pineapple217/MetaRaid/pkg/config"
 	"github.com/Pineapple217/MetaRaid/pkg/database"
 	"github.com/Pineapple217/MetaRaid/pkg/helper"
 	"github.com/Pineapple217/MetaRaid/pkg/scraper"
+	"github.com/Pineapple217/MetaRaid/pkg/server"
 	"github.com/Pineapple217/MetaRaid/pkg/spotify"
+	"github.com/Pineapple217/MetaRaid/pkg/tracing"
 )
 
 const banner = `
@@ -32,15 +37,39 @@ func main() {
 	conf, err := config.Load()
 	helper.MaybeDie(err, "Failed to load configs")
 
+	shutdownTracing, err := tracing.Init(context.Background(), conf.Tracing, "metaraid-scraper")
+	helper.MaybeDie(err, "Failed to set up tracing")
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		err := shutdownTracing(ctx)
+		if err != nil {
+			slog.Warn("Failed to flush traces", "error", err)
+		}
+	}()
+
 	rdb := database.NewRedis(conf.Redis)
 	clients := spotify.NewClient(conf.Spotify)
 
-	s := scraper.NewScraper(clients, rdb, conf.Scraper)
+	s := scraper.NewScraper(clients, rdb, conf.Scraper, conf.Webhook)
 	s.Start()
 	defer s.Stop()
 
+	err = config.Watch(func(c config.Config) {
+		s.UpdateClients(c.Spotify)
+	})
+	if err != nil {
+		slog.Warn("Failed to watch configs, keys will not be reloaded", "error", err)
+	}
+
+	if conf.Server.Addr != "" {
+		srv := server.New(conf.Server, s)
+		srv.Start()
+		defer srv.Stop()
+	}
+
 	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, os.Interrupt)
-	<-quit
-	slog.Info("Received an interrupt signal, exiting...")
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+	sig := <-quit
+	slog.Info("Received a signal, exiting...", "signal", sig)
 }
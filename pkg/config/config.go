@@ -3,6 +3,8 @@ package config
 import (
 	"log/slog"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/knadh/koanf"
 	"github.com/knadh/koanf/parsers/yaml"
@@ -15,15 +17,27 @@ import (
 type Config struct {
 	Redis   Redis   `yaml:"redis"`
 	Scraper Scraper `yaml:"scraper"`
+	Server  Server  `yaml:"server"`
 	Spotify Spotify `yaml:"spotify"`
+	Tracing Tracing `yaml:"tracing"`
+	Webhook Webhook `yaml:"webhook"`
 }
 
 func (c *Config) SetDefault() {
 	c.Redis.SetDefault()
 	c.Scraper.SetDefault()
+	c.Server.SetDefault()
 	c.Spotify.SetDefault()
+	c.Tracing.SetDefault()
+	c.Webhook.SetDefault()
 }
 
+const path = "./config.yaml"
+
+// Editors often truncate the file before writing it, reloads wait until it
+// has not been written for reloadDelay so they do not see it half written.
+const reloadDelay = 500 * time.Millisecond
+
 func Load() (Config, error) {
 	slog.Info("Loading configs")
 	k := koanf.New(".")
@@ -31,7 +45,7 @@ func Load() (Config, error) {
 	var conf Config
 	conf.SetDefault()
 
-	err := k.Load(file.Provider("./config.yaml"), yaml.Parser())
+	err := k.Load(file.Provider(path), yaml.Parser())
 	if err != nil {
 		return Config{}, err
 	}
@@ -87,5 +101,34 @@ func Load() (Config, error) {
 		return Config{}, err
 	}
 
+	err = conf.Spotify.Validate()
+	if err != nil {
+		return Config{}, err
+	}
+
 	return conf, nil
 }
+
+// Watch calls onChange with the reloaded configs every time the config file
+// is written. Configs that fail to load are logged and skipped.
+func Watch(onChange func(Config)) error {
+	var mu sync.Mutex
+	reload := time.AfterFunc(reloadDelay, func() {
+		mu.Lock()
+		defer mu.Unlock()
+		conf, err := Load()
+		if err != nil {
+			slog.Warn("Failed to reload configs", "error", err)
+			return
+		}
+		onChange(conf)
+	})
+	reload.Stop()
+	return file.Provider(path).Watch(func(event any, err error) {
+		if err != nil {
+			slog.Warn("Stopped watching configs", "error", err)
+			return
+		}
+		reload.Reset(reloadDelay)
+	})
+}
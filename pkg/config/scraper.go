@@ -1,11 +1,26 @@
 package config
 
+import "time"
+
 type Scraper struct {
 	SeedArtistId string `yaml:"seedArtistId"`
 	WorkerCount  int    `yaml:"workerCount"`
+	// How long Stop waits for running jobs to be cancelled and requeued
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout"`
+	// Jobs running longer than this are reported as stalled, 0 disables it
+	StallTimeout time.Duration `yaml:"stallTimeout"`
+	// Cancel stalled jobs and return them to the pending queue
+	AbortStalled bool `yaml:"abortStalled"`
+	// Shut down when no worker runs and every key stays cold for longer
+	// than this, 0 keeps waiting for the keys to recover
+	MaxKeyCooldown time.Duration `yaml:"maxKeyCooldown"`
 }
 
 func (s *Scraper) SetDefault() {
 	s.SeedArtistId = "5D8TBtxnP5GZm9wUBQ8OTc" // Istasha
 	s.WorkerCount = 5
+	s.ShutdownTimeout = 30 * time.Second
+	s.StallTimeout = 30 * time.Minute
+	s.AbortStalled = false
+	s.MaxKeyCooldown = 24 * time.Hour
 }
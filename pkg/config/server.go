@@ -0,0 +1,17 @@
+package config
+
+type Server struct {
+	// Address of the HTTP listener, e.g. ":8080". Empty disables it
+	Addr    string `yaml:"addr"`
+	Metrics bool   `yaml:"metrics"`
+	Admin   bool   `yaml:"admin"`
+	// Bearer token required by the admin API, empty allows everyone
+	AdminToken string `yaml:"adminToken"`
+}
+
+func (s *Server) SetDefault() {
+	s.Addr = ""
+	s.Metrics = true
+	s.Admin = false
+	s.AdminToken = ""
+}
@@ -1,16 +1,51 @@
 package config
 
-import "time"
+import (
+	"errors"
+	"time"
+)
 
 type Spotify struct {
-	Clients []struct {
-		ClientId     string `yaml:"clientId"`
-		ClientSecret string `yaml:"clientSecret"`
-		Name         string `yaml:"name"`
-	} `yaml:"clients"`
-	MaxRetryDuration time.Duration `yaml:"maxRetryDuration"`
+	Clients          []SpotifyClient `yaml:"clients"`
+	MaxRetryDuration time.Duration   `yaml:"maxRetryDuration"`
+	// Client side limit of requests per key per 30 seconds, 0 disables it
+	RateLimit int `yaml:"rateLimit"`
+	// Number of batch requests a single job may run at the same time, all
+	// of them still share the key's rate limit
+	Concurrency int `yaml:"concurrency"`
+	// ISO 3166-1 alpha-2 codes, the first market is used for relinking and
+	// playability is stored for all of them. Spotify then leaves out
+	// available_markets, so the export has it as NULL
+	Markets []string `yaml:"markets"`
+	// Also crawl albums of other artists the artist appears on
+	IncludeAppearsOn bool `yaml:"includeAppearsOn"`
+	AudioAnalysis    struct {
+		Enabled bool `yaml:"enabled"`
+		// Budget per key, separate from the main crawl. Stored tracks wait
+		// in a backlog until a key has budget for them
+		RequestsPerMinute int `yaml:"requestsPerMinute"`
+	} `yaml:"audioAnalysis"`
+}
+
+type SpotifyClient struct {
+	ClientId     string `yaml:"clientId"`
+	ClientSecret string `yaml:"clientSecret"`
+	Name         string `yaml:"name"`
 }
 
 func (s *Spotify) SetDefault() {
 	s.MaxRetryDuration = time.Hour
+	s.RateLimit = 90
+	s.Concurrency = 4
+	s.Markets = []string{}
+	s.IncludeAppearsOn = false
+	s.AudioAnalysis.Enabled = false
+	s.AudioAnalysis.RequestsPerMinute = 30
+}
+
+func (s *Spotify) Validate() error {
+	if s.AudioAnalysis.Enabled && s.AudioAnalysis.RequestsPerMinute <= 0 {
+		return errors.New("spotify.audioAnalysis.requestsPerMinute must be at least 1")
+	}
+	return nil
 }
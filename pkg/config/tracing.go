@@ -0,0 +1,18 @@
+package config
+
+type Tracing struct {
+	// "stdout", "otlp" or empty to disable tracing
+	Exporter string `yaml:"exporter"`
+	// host:port of the OTLP/HTTP collector
+	Endpoint string `yaml:"endpoint"`
+	Insecure bool   `yaml:"insecure"`
+	// Share of jobs that are traced, between 0 and 1
+	SampleRatio float64 `yaml:"sampleRatio"`
+}
+
+func (t *Tracing) SetDefault() {
+	t.Exporter = ""
+	t.Endpoint = "localhost:4318"
+	t.Insecure = true
+	t.SampleRatio = 1
+}
@@ -0,0 +1,26 @@
+package config
+
+import "time"
+
+type Webhook struct {
+	// Every notification is posted as JSON to each url
+	Urls []string `yaml:"urls"`
+	// Attempts per url, retried with exponential backoff
+	Retries int           `yaml:"retries"`
+	Timeout time.Duration `yaml:"timeout"`
+	// The same notification is not sent again within this window
+	Dedup time.Duration `yaml:"dedup"`
+	// Share of jobs failing in a minute that counts as an error rate spike
+	ErrorRate float64 `yaml:"errorRate"`
+	// Jobs that have to finish in a minute before the error rate is checked
+	ErrorRateMinJobs int `yaml:"errorRateMinJobs"`
+}
+
+func (w *Webhook) SetDefault() {
+	w.Urls = []string{}
+	w.Retries = 3
+	w.Timeout = 10 * time.Second
+	w.Dedup = 15 * time.Minute
+	w.ErrorRate = 0.5
+	w.ErrorRateMinJobs = 10
+}
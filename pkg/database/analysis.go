@@ -0,0 +1,104 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	spt "github.com/Pineapple217/MetaRaid/pkg/spotify"
+	"github.com/redis/go-redis/v9"
+)
+
+// Tracks waiting for an audio analysis are pushed on analysis_backlog and
+// moved to analysis_working while they are fetched, so a crash does not lose
+// them.
+const (
+	analysisBacklogKey = "analysis_backlog"
+	analysisWorkingKey = "analysis_working"
+)
+
+// QueueAnalysis adds stored tracks to the audio analysis backlog.
+func QueueAnalysis(rdb *redis.Client, ctx context.Context, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	members := make([]any, len(ids))
+	for i, id := range ids {
+		members[i] = id
+	}
+	return rdb.LPush(ctx, analysisBacklogKey, members...).Err()
+}
+
+// PopAnalysis moves the oldest track of the backlog to analysis_working. It
+// returns an empty id when the backlog stayed empty for timeout.
+func PopAnalysis(rdb *redis.Client, ctx context.Context, timeout time.Duration) (string, error) {
+	id, err := rdb.BLMove(ctx, analysisBacklogKey, analysisWorkingKey, "RIGHT", "LEFT", timeout).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return id, err
+}
+
+// SetTrackAnalysis adds the summary to the stored track and removes it from
+// analysis_working. A track that is no longer stored is dropped.
+func SetTrackAnalysis(rdb *redis.Client, ctx context.Context, id string, a *spt.AnalysisSummary) error {
+	key := "tracks:" + id
+	b, err := rdb.Get(ctx, key).Bytes()
+	if err == redis.Nil {
+		return DropAnalysis(rdb, ctx, id)
+	}
+	if err != nil {
+		return err
+	}
+	track, err := spt.DeserializeFullerTrack(b)
+	if err != nil {
+		return fmt.Errorf("failed to deserialize track: %w", err)
+	}
+	track.Analysis = a
+	b, err = track.Serialize()
+	if err != nil {
+		return fmt.Errorf("failed to serialize track: %w", err)
+	}
+
+	pipe := rdb.TxPipeline()
+	pipe.Set(ctx, key, b, 0)
+	pipe.LRem(ctx, analysisWorkingKey, 1, id)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// DropAnalysis removes a track without an analysis from analysis_working.
+func DropAnalysis(rdb *redis.Client, ctx context.Context, id string) error {
+	return rdb.LRem(ctx, analysisWorkingKey, 1, id).Err()
+}
+
+// RequeueAnalysis returns a track from analysis_working to the front of the
+// backlog.
+func RequeueAnalysis(rdb *redis.Client, ctx context.Context, id string) error {
+	pipe := rdb.TxPipeline()
+	pipe.LRem(ctx, analysisWorkingKey, 1, id)
+	pipe.RPush(ctx, analysisBacklogKey, id)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// RecoverAnalysis returns the tracks a previous run was analysing to the
+// backlog.
+func RecoverAnalysis(rdb *redis.Client, ctx context.Context) error {
+	n := 0
+	for {
+		err := rdb.LMove(ctx, analysisWorkingKey, analysisBacklogKey, "RIGHT", "RIGHT").Err()
+		if err == redis.Nil {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		n++
+	}
+	if n > 0 {
+		slog.Info("recovered audio analysis backlog", "count", n)
+	}
+	return nil
+}
@@ -4,11 +4,12 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
-	"strings"
+	"time"
 
 	"github.com/Pineapple217/MetaRaid/pkg/config"
 	"github.com/Pineapple217/MetaRaid/pkg/helper"
 	spt "github.com/Pineapple217/MetaRaid/pkg/spotify"
+	"github.com/redis/go-redis/extra/redisotel/v9"
 	"github.com/redis/go-redis/v9"
 	"github.com/zmb3/spotify/v2"
 )
@@ -23,48 +24,118 @@ func NewRedis(conf config.Redis) *redis.Client {
 	s := rdb.Ping(context.Background())
 	helper.MaybeDie(s.Err(), "Failed to connect to redis DB")
 
+	// spans are only recorded once a tracer provider is installed
+	err := redisotel.InstrumentTracing(rdb)
+	helper.MaybeDie(err, "Failed to instrument redis")
+
 	return rdb
 }
 
+// Job key scheme, every function and script in this package uses it:
+//
+//	jobs_pending, jobs_working, jobs_done, jobs_failed
+//	    sets of bare artist ids, a job is in exactly one of them
+//	jobs:<id>
+//	    hash of a job, "status" matches the set it is in, "error" holds the
+//	    reason of a failed job and "tracks" the number of tracks stored, set
+//	    when the job is first popped. "skipped" counts the albums and tracks
+//	    left to other jobs, see index.go
+//	jobs_signal
+//	    list with at most one element, pushed whenever jobs become pending so
+//	    idle fetchers can block on it instead of polling
+//	events
+//	    stream of job lifecycle events, see events.go
+//	albums_*, tracks_*, claims:<job>:*
+//	    the album and track index, see index.go
+//	analysis_backlog, analysis_working
+//	    tracks waiting for an audio analysis, see analysis.go
+//	stats:tracks, stats:progress
+//	    number of tracks inserted and progress samples, see progress.go
+//
+// The scripts build jobs:<id> themselves, keep jobPrefix in sync with them.
+const (
+	pendingKey = "jobs_pending"
+	workingKey = "jobs_working"
+	doneKey    = "jobs_done"
+	failedKey  = "jobs_failed"
+	signalKey  = "jobs_signal"
+	jobPrefix  = "jobs:"
+)
+
+func jobKey(id string) string {
+	return jobPrefix + id
+}
+
+// signalJobs wakes a fetcher waiting in WaitForJobs.
+func signalJobs(pipe redis.Pipeliner, ctx context.Context) {
+	pipe.LPush(ctx, signalKey, 1)
+	pipe.LTrim(ctx, signalKey, 0, 0)
+}
+
+// WaitForJobs blocks until jobs were added or requeued since the last call
+// or timeout has passed. Cancelling ctx does not interrupt the blocking pop,
+// so keep timeout short. Only one waiting fetcher is woken per signal.
+func WaitForJobs(rdb *redis.Client, ctx context.Context, timeout time.Duration) error {
+	err := rdb.BLPop(ctx, timeout, signalKey).Err()
+	if err == redis.Nil {
+		return nil
+	}
+	return err
+}
+
 var addJobsScript = redis.NewScript(`
     local pendingKey = KEYS[1]
+    local signalKey = KEYS[2]
     local results = {}
+    local added = 0
 
-    for i, jobKey in ipairs(ARGV) do
-        local statusSet = redis.call("HSETNX", jobKey, "status", "pending")
+    for i, job in ipairs(ARGV) do
+        local statusSet = redis.call("HSETNX", "jobs:" .. job, "status", "pending")
         if statusSet == 1 then
-            redis.call("SADD", pendingKey, jobKey)
+            redis.call("SADD", pendingKey, job)
+            added = added + 1
         end
         results[i] = statusSet
     end
 
+    if added > 0 then
+        redis.call("LPUSH", signalKey, 1)
+        redis.call("LTRIM", signalKey, 0, 0)
+    end
+
     return results
 `)
 
 func AddJobs(rdb *redis.Client, ctx context.Context, jobs []spotify.ID) error {
-	jobKeys := make([]string, len(jobs))
+	ids := make([]any, len(jobs))
 	for i, job := range jobs {
-		jobKeys[i] = "jobs:" + job.String()
+		ids[i] = job.String()
 	}
 
-	results, err := addJobsScript.Run(ctx, rdb, []string{"jobs_pending"}, jobKeys).Result()
+	results, err := addJobsScript.Run(ctx, rdb, []string{pendingKey, signalKey}, ids...).Result()
 	if err != nil {
 		return err
 	}
 
+	pipe := rdb.Pipeline()
 	for i, job := range jobs {
 		wasSet := results.([]any)[i].(int64)
 		if wasSet == 1 {
+			addEvent(pipe, ctx, Event{Type: JobAdded, Job: job.String()})
 			slog.Debug("job added to queue", "job", job.String())
 		} else {
 			slog.Debug("job already exists", "job", job.String())
 		}
 	}
-	return nil
+	if pipe.Len() == 0 {
+		return nil
+	}
+	_, err = pipe.Exec(ctx)
+	return err
 }
 
 func EnsureSeedJob(rdb *redis.Client, ctx context.Context, seedTask string) error {
-	queueLength, err := rdb.SCard(ctx, "jobs_pending").Result()
+	queueLength, err := rdb.SCard(ctx, pendingKey).Result()
 	if err != nil {
 		return err
 	}
@@ -89,8 +160,9 @@ var popJobsScript = redis.NewScript(`
     end
 
     for i, job in ipairs(jobs) do
-        local jobKey = "jobs:" .. job
-        redis.call("HSET", jobKey, "status", "working")
+        redis.call("HSET", "jobs:" .. job, "status", "working")
+        -- keeps the count of an earlier attempt
+        redis.call("HSETNX", "jobs:" .. job, "tracks", 0)
         redis.call("SADD", workingKey, job)
     end
 
@@ -98,14 +170,14 @@ var popJobsScript = redis.NewScript(`
 `)
 
 func PopJobs(rdb *redis.Client, ctx context.Context, count int) ([]string, error) {
-	results, err := popJobsScript.Run(ctx, rdb, []string{"jobs_pending", "jobs_working"}, count).Result()
+	results, err := popJobsScript.Run(ctx, rdb, []string{pendingKey, workingKey}, count).Result()
 	if err != nil {
 		return nil, err
 	}
 
 	var jobsOut []string
 	for _, job := range results.([]interface{}) {
-		jobsOut = append(jobsOut, strings.TrimPrefix(job.(string), "jobs:"))
+		jobsOut = append(jobsOut, job.(string))
 	}
 
 	return jobsOut, nil
@@ -119,7 +191,7 @@ var recoverInProgressTasksScript = redis.NewScript(`
     local jobs = redis.call("SMEMBERS", workingKey)
     
     if #jobs == 0 then
-        return 0  -- No jobs to recover
+        return {}  -- No jobs to recover
     end
 
     -- Move each job to jobs_pending and update status
@@ -131,29 +203,42 @@ var recoverInProgressTasksScript = redis.NewScript(`
     -- Remove all jobs from jobs_working
     redis.call("DEL", workingKey)
 
-    return #jobs
+    return jobs
 `)
 
+// RecoverInProgressTasks returns the jobs of a previous run that did not
+// finish to the pending queue and releases the albums and tracks they had
+// claimed but not stored.
 func RecoverInProgressTasks(rdb *redis.Client, ctx context.Context) error {
-	result, err := recoverInProgressTasksScript.Run(ctx, rdb, []string{"jobs_working", "jobs_pending"}).Result()
+	jobs, err := recoverInProgressTasksScript.Run(ctx, rdb, []string{workingKey, pendingKey}).StringSlice()
 	if err != nil {
 		return err
 	}
 
-	if result.(int64) == 0 {
+	if len(jobs) == 0 {
 		slog.Info("no jobs to recover")
-	} else {
-		slog.Info("recovered jobs", "count", result.(int64))
+		return nil
 	}
-
+	released, err := releaseJobClaims(rdb, ctx, jobs)
+	if err != nil {
+		return err
+	}
+	slog.Info("recovered jobs", "count", len(jobs), "released_claims", released)
 	return nil
 }
 
+// AddJobTracks adds n to the number of tracks stored for a job, so partially
+// completed jobs can be told apart.
+func AddJobTracks(rdb *redis.Client, ctx context.Context, job string, n int) error {
+	return rdb.HIncrBy(ctx, jobKey(job), "tracks", int64(n)).Err()
+}
+
 func MarkJobDone(rdb *redis.Client, ctx context.Context, job string) error {
 	pipe := rdb.TxPipeline()
-	pipe.SRem(ctx, "jobs_working", job)
-	pipe.SAdd(ctx, "jobs_done", job)
-	pipe.HSet(ctx, "jobs:"+job, "status", "done")
+	pipe.SRem(ctx, workingKey, job)
+	pipe.SAdd(ctx, doneKey, job)
+	pipe.HSet(ctx, jobKey(job), "status", "done")
+	addEvent(pipe, ctx, Event{Type: JobDone, Job: job})
 
 	_, err := pipe.Exec(ctx)
 	if err != nil {
@@ -163,17 +248,121 @@ func MarkJobDone(rdb *redis.Client, ctx context.Context, job string) error {
 	return nil
 }
 
-func InsertTracks(rdb *redis.Client, ctx context.Context, tracks []*spt.FullerTrack) error {
+// RequeueJobs moves jobs that were being worked on back to the pending queue.
+func RequeueJobs(rdb *redis.Client, ctx context.Context, jobs []string) error {
+	if len(jobs) == 0 {
+		return nil
+	}
+	pipe := rdb.TxPipeline()
+	for _, job := range jobs {
+		pipe.SRem(ctx, workingKey, job)
+		pipe.SAdd(ctx, pendingKey, job)
+		pipe.HSet(ctx, jobKey(job), "status", "pending")
+	}
+	signalJobs(pipe, ctx)
+
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		return err
+	}
+	slog.Info("Requeued tasks", "count", len(jobs))
+	return nil
+}
+
+func RequeueJob(rdb *redis.Client, ctx context.Context, job string) error {
+	return RequeueJobs(rdb, ctx, []string{job})
+}
+
+func MarkJobFailed(rdb *redis.Client, ctx context.Context, job string, reason error) error {
+	pipe := rdb.TxPipeline()
+	pipe.SRem(ctx, workingKey, job)
+	pipe.SAdd(ctx, failedKey, job)
+	pipe.HSet(ctx, jobKey(job), "status", "failed", "error", reason.Error())
+	addEvent(pipe, ctx, Event{Type: JobFailed, Job: job, Error: reason.Error()})
+
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		return err
+	}
+	slog.Warn("Marked task as failed", "task", job, "error", reason)
+	return nil
+}
+
+var requeueFailedJobsScript = redis.NewScript(`
+    local failedKey = KEYS[1]
+    local pendingKey = KEYS[2]
+    local signalKey = KEYS[3]
+
+    local jobs = redis.call("SMEMBERS", failedKey)
+
+    for i, job in ipairs(jobs) do
+        local jobKey = "jobs:" .. job
+        redis.call("SADD", pendingKey, job)
+        redis.call("HSET", jobKey, "status", "pending")
+        redis.call("HDEL", jobKey, "error")
+    end
+
+    redis.call("DEL", failedKey)
+    if #jobs > 0 then
+        redis.call("LPUSH", signalKey, 1)
+        redis.call("LTRIM", signalKey, 0, 0)
+    end
+
+    return #jobs
+`)
+
+// RequeueFailedJobs moves all failed jobs back to the pending queue and
+// returns how many were moved.
+func RequeueFailedJobs(rdb *redis.Client, ctx context.Context) (int64, error) {
+	result, err := requeueFailedJobsScript.Run(ctx, rdb, []string{failedKey, pendingKey, signalKey}).Result()
+	if err != nil {
+		return 0, err
+	}
+	slog.Info("requeued failed jobs", "count", result.(int64))
+	return result.(int64), nil
+}
+
+type QueueSizes struct {
+	Pending int64
+	Working int64
+	Done    int64
+	Failed  int64
+}
+
+func GetQueueSizes(rdb *redis.Client, ctx context.Context) (QueueSizes, error) {
+	pipe := rdb.Pipeline()
+	pending := pipe.SCard(ctx, pendingKey)
+	working := pipe.SCard(ctx, workingKey)
+	done := pipe.SCard(ctx, doneKey)
+	failed := pipe.SCard(ctx, failedKey)
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		return QueueSizes{}, err
+	}
+	return QueueSizes{
+		Pending: pending.Val(),
+		Working: working.Val(),
+		Done:    done.Val(),
+		Failed:  failed.Val(),
+	}, nil
+}
+
+// InsertTracks stores the tracks fetched for job.
+func InsertTracks(rdb *redis.Client, ctx context.Context, job string, tracks []*spt.FullerTrack) error {
 	pipe := rdb.Pipeline()
 
-	for _, track := range tracks {
+	ids := make([]string, len(tracks))
+	for i, track := range tracks {
 		key := "tracks:" + string(track.Track.ID)
 		serializedData, err := track.Serialize()
 		if err != nil {
 			return fmt.Errorf("failed to serialize data: %w", err)
 		}
 		pipe.Set(ctx, key, serializedData, 0)
+		ids[i] = string(track.Track.ID)
 	}
+	addEvent(pipe, ctx, Event{Type: TracksInserted, Job: job, Tracks: ids})
+	pipe.IncrBy(ctx, tracksCountKey, int64(len(tracks)))
 
 	_, err := pipe.Exec(ctx)
 	return err
@@ -0,0 +1,83 @@
+package database
+
+import (
+	"context"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Job lifecycle events are added to the events stream, trimmed to about
+// eventsMaxLen entries. Every entry has a "type" field and, depending on the
+// type, "job", "key", "worker", "status", "error" and "tracks" (comma
+// separated track ids).
+// Consumers read it with a consumer group, e.g.
+//
+//	XGROUP CREATE events indexer $ MKSTREAM
+//	XREADGROUP GROUP indexer <consumer> BLOCK 0 STREAMS events >
+const (
+	eventsKey    = "events"
+	eventsMaxLen = 100_000
+)
+
+type EventType string
+
+const (
+	JobAdded       EventType = "job_added"
+	JobStarted     EventType = "job_started"
+	JobDone        EventType = "job_done"
+	JobFailed      EventType = "job_failed"
+	KeyCold        EventType = "key_cold"
+	KeyWarm        EventType = "key_warm"
+	TracksInserted EventType = "tracks_inserted"
+	WorkerStatus   EventType = "worker_status"
+)
+
+type Event struct {
+	Type   EventType
+	Job    string
+	Key    string
+	Worker string
+	Status string
+	Error  string
+	Tracks []string
+}
+
+func (e Event) values() []any {
+	v := []any{"type", string(e.Type)}
+	if e.Job != "" {
+		v = append(v, "job", e.Job)
+	}
+	if e.Key != "" {
+		v = append(v, "key", e.Key)
+	}
+	if e.Worker != "" {
+		v = append(v, "worker", e.Worker)
+	}
+	if e.Status != "" {
+		v = append(v, "status", e.Status)
+	}
+	if e.Error != "" {
+		v = append(v, "error", e.Error)
+	}
+	if len(e.Tracks) > 0 {
+		v = append(v, "tracks", strings.Join(e.Tracks, ","))
+	}
+	return v
+}
+
+// addEvent queues e on c, which can be a pipeline so the event is written
+// together with the change it describes.
+func addEvent(c redis.Cmdable, ctx context.Context, e Event) *redis.StringCmd {
+	return c.XAdd(ctx, &redis.XAddArgs{
+		Stream: eventsKey,
+		MaxLen: eventsMaxLen,
+		Approx: true,
+		Values: e.values(),
+	})
+}
+
+// PublishEvent adds an event that is not tied to a change in the job state.
+func PublishEvent(rdb *redis.Client, ctx context.Context, e Event) error {
+	return addEvent(rdb, ctx, e).Err()
+}
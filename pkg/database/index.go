@@ -0,0 +1,217 @@
+package database
+
+import (
+	"context"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/zmb3/spotify/v2"
+)
+
+// Index is a Redis backed record of the albums and tracks that have already
+// been fetched, shared by every worker in the crawl.
+//
+//	albums_fetched, tracks_fetched
+//	    sets of ids whose tracks are stored
+//	albums_claimed, tracks_claimed
+//	    hashes of the ids a job is fetching right now to the job
+//	claims:<job>:albums, claims:<job>:tracks
+//	    sets of the ids claimed by a job, so they can be released when the
+//	    job dies without releasing them itself
+//
+// A claim only becomes fetched once it is committed, claims of a job that
+// was recovered or repaired are released so the retry fetches them again.
+// Ids that were fetched or claimed by another job are added to the
+// "skipped" field of the job hash.
+type Index struct {
+	rdb *redis.Client
+}
+
+func NewIndex(rdb *redis.Client) *Index {
+	return &Index{rdb: rdb}
+}
+
+type indexKind struct {
+	fetched string
+	claimed string
+	suffix  string
+}
+
+var (
+	albumsIndex = indexKind{"albums_fetched", "albums_claimed", ":albums"}
+	tracksIndex = indexKind{"tracks_fetched", "tracks_claimed", ":tracks"}
+)
+
+func claimsKey(job string, kind indexKind) string {
+	return "claims:" + job + kind.suffix
+}
+
+var claimScript = redis.NewScript(`
+    local fetchedKey = KEYS[1]
+    local claimedKey = KEYS[2]
+    local jobClaimsKey = KEYS[3]
+    local jobKey = KEYS[4]
+    local job = ARGV[1]
+    local claimed = {}
+    local skipped = 0
+
+    for i = 2, #ARGV do
+        local id = ARGV[i]
+        local take = false
+        if redis.call("SISMEMBER", fetchedKey, id) == 0 then
+            local owner = redis.call("HGET", claimedKey, id)
+            take = not owner or owner == job
+        end
+        if take then
+            redis.call("HSET", claimedKey, id, job)
+            redis.call("SADD", jobClaimsKey, id)
+            table.insert(claimed, id)
+        else
+            skipped = skipped + 1
+        end
+    end
+    if skipped > 0 then
+        redis.call("HINCRBY", jobKey, "skipped", skipped)
+    end
+
+    return claimed
+`)
+
+var releaseScript = redis.NewScript(`
+    local claimedKey = KEYS[1]
+    local jobClaimsKey = KEYS[2]
+    local job = ARGV[1]
+
+    for i = 2, #ARGV do
+        local id = ARGV[i]
+        if redis.call("HGET", claimedKey, id) == job then
+            redis.call("HDEL", claimedKey, id)
+        end
+        redis.call("SREM", jobClaimsKey, id)
+    end
+
+    return 1
+`)
+
+var commitScript = redis.NewScript(`
+    local fetchedKey = KEYS[1]
+    local claimedKey = KEYS[2]
+    local jobClaimsKey = KEYS[3]
+
+    for i = 1, #ARGV do
+        local id = ARGV[i]
+        redis.call("SADD", fetchedKey, id)
+        redis.call("HDEL", claimedKey, id)
+        redis.call("SREM", jobClaimsKey, id)
+    end
+
+    return 1
+`)
+
+func idArgs(ids []spotify.ID) []any {
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id.String()
+	}
+	return args
+}
+
+func claim(rdb *redis.Client, ctx context.Context, kind indexKind, job string, ids []spotify.ID) ([]spotify.ID, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	keys := []string{kind.fetched, kind.claimed, claimsKey(job, kind), jobKey(job)}
+	results, err := claimScript.Run(ctx, rdb, keys, append([]any{job}, idArgs(ids)...)...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var out []spotify.ID
+	for _, id := range results.([]any) {
+		out = append(out, spotify.ID(id.(string)))
+	}
+	return out, nil
+}
+
+func release(rdb *redis.Client, ctx context.Context, kind indexKind, job string, ids []spotify.ID) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	keys := []string{kind.claimed, claimsKey(job, kind)}
+	return releaseScript.Run(ctx, rdb, keys, append([]any{job}, idArgs(ids)...)...).Err()
+}
+
+func commit(rdb *redis.Client, ctx context.Context, kind indexKind, job string, ids []spotify.ID) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	keys := []string{kind.fetched, kind.claimed, claimsKey(job, kind)}
+	return commitScript.Run(ctx, rdb, keys, idArgs(ids)...).Err()
+}
+
+// ClaimAlbums claims the albums for job and returns the ones that are
+// neither fetched nor claimed by another job.
+func (i *Index) ClaimAlbums(ctx context.Context, job string, ids []spotify.ID) ([]spotify.ID, error) {
+	return claim(i.rdb, ctx, albumsIndex, job, ids)
+}
+
+// ReleaseAlbums undoes a claim for albums that could not be fetched.
+func (i *Index) ReleaseAlbums(ctx context.Context, job string, ids []spotify.ID) error {
+	return release(i.rdb, ctx, albumsIndex, job, ids)
+}
+
+// CommitAlbums marks claimed albums as fetched, once all their tracks are
+// stored.
+func (i *Index) CommitAlbums(ctx context.Context, job string, ids []spotify.ID) error {
+	return commit(i.rdb, ctx, albumsIndex, job, ids)
+}
+
+// ClaimTracks claims the tracks for job and returns the ones that are
+// neither fetched nor claimed by another job.
+func (i *Index) ClaimTracks(ctx context.Context, job string, ids []spotify.ID) ([]spotify.ID, error) {
+	return claim(i.rdb, ctx, tracksIndex, job, ids)
+}
+
+// ReleaseTracks undoes a claim for tracks that could not be fetched.
+func (i *Index) ReleaseTracks(ctx context.Context, job string, ids []spotify.ID) error {
+	return release(i.rdb, ctx, tracksIndex, job, ids)
+}
+
+// CommitTracks marks claimed tracks as fetched, once they are stored.
+func (i *Index) CommitTracks(ctx context.Context, job string, ids []spotify.ID) error {
+	return commit(i.rdb, ctx, tracksIndex, job, ids)
+}
+
+var releaseJobClaimsScript = redis.NewScript(`
+    local albumsClaimedKey = KEYS[1]
+    local tracksClaimedKey = KEYS[2]
+    local released = 0
+
+    for i, job in ipairs(ARGV) do
+        for claimedKey, suffix in pairs({[albumsClaimedKey] = ":albums", [tracksClaimedKey] = ":tracks"}) do
+            local jobClaimsKey = "claims:" .. job .. suffix
+            for j, id in ipairs(redis.call("SMEMBERS", jobClaimsKey)) do
+                if redis.call("HGET", claimedKey, id) == job then
+                    redis.call("HDEL", claimedKey, id)
+                    released = released + 1
+                end
+            end
+            redis.call("DEL", jobClaimsKey)
+        end
+    end
+
+    return released
+`)
+
+// releaseJobClaims releases everything the jobs claimed and did not commit,
+// for jobs whose worker died without releasing them. It returns the number
+// of released claims.
+func releaseJobClaims(rdb *redis.Client, ctx context.Context, jobs []string) (int64, error) {
+	if len(jobs) == 0 {
+		return 0, nil
+	}
+	args := make([]any, len(jobs))
+	for i, job := range jobs {
+		args[i] = job
+	}
+	return releaseJobClaimsScript.Run(ctx, rdb, []string{albumsIndex.claimed, tracksIndex.claimed}, args...).Int64()
+}
@@ -0,0 +1,133 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// total number of tracks inserted, counted since it was introduced
+	tracksCountKey = "stats:tracks"
+	// list of progress samples, newest first
+	progressKey = "stats:progress"
+	// one day of samples taken every minute
+	progressSamples = 24 * 60
+)
+
+type ProgressSample struct {
+	At      time.Time `json:"at"`
+	Pending int64     `json:"pending"`
+	Working int64     `json:"working"`
+	Done    int64     `json:"done"`
+	Failed  int64     `json:"failed"`
+	Tracks  int64     `json:"tracks"`
+}
+
+// Jobs returns the number of artists found so far.
+func (s ProgressSample) Jobs() int64 {
+	return s.Pending + s.Working + s.Done + s.Failed
+}
+
+// SampleProgress reads the current queue sizes and track count.
+func SampleProgress(rdb *redis.Client, ctx context.Context) (ProgressSample, error) {
+	sizes, err := GetQueueSizes(rdb, ctx)
+	if err != nil {
+		return ProgressSample{}, err
+	}
+	tracks, err := rdb.Get(ctx, tracksCountKey).Int64()
+	if err != nil && err != redis.Nil {
+		return ProgressSample{}, err
+	}
+	return ProgressSample{
+		At:      time.Now(),
+		Pending: sizes.Pending,
+		Working: sizes.Working,
+		Done:    sizes.Done,
+		Failed:  sizes.Failed,
+		Tracks:  tracks,
+	}, nil
+}
+
+func SaveProgressSample(rdb *redis.Client, ctx context.Context, s ProgressSample) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	pipe := rdb.Pipeline()
+	pipe.LPush(ctx, progressKey, data)
+	pipe.LTrim(ctx, progressKey, 0, progressSamples-1)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// GetProgressSamples returns the samples taken within window, oldest first.
+func GetProgressSamples(rdb *redis.Client, ctx context.Context, window time.Duration) ([]ProgressSample, error) {
+	raw, err := rdb.LRange(ctx, progressKey, 0, progressSamples-1).Result()
+	if err != nil {
+		return nil, err
+	}
+	since := time.Now().Add(-window)
+	samples := []ProgressSample{}
+	for _, r := range raw {
+		var s ProgressSample
+		err = json.Unmarshal([]byte(r), &s)
+		if err != nil {
+			return nil, err
+		}
+		if s.At.Before(since) {
+			break
+		}
+		samples = append(samples, s)
+	}
+	// the list is newest first
+	for i, j := 0, len(samples)-1; i < j; i, j = i+1, j-1 {
+		samples[i], samples[j] = samples[j], samples[i]
+	}
+	return samples, nil
+}
+
+type Progress struct {
+	Latest ProgressSample
+	// Time between the first and last sample the rates are based on
+	Window          time.Duration
+	JobsPerMinute   float64
+	TracksPerMinute float64
+	// New artists found per minute
+	DiscoveredPerMinute float64
+	// Change of the pending queue per minute, the frontier grows while it
+	// is positive
+	FrontierGrowth float64
+	// Estimated time until the pending queue is empty, 0 while it grows
+	ETA time.Duration
+}
+
+// EstimateProgress derives rates and the time to exhaustion from the first
+// and last of samples, which must be ordered oldest first. It returns false
+// when there are not enough samples.
+func EstimateProgress(samples []ProgressSample) (Progress, bool) {
+	if len(samples) < 2 {
+		return Progress{}, false
+	}
+	first, last := samples[0], samples[len(samples)-1]
+	window := last.At.Sub(first.At)
+	minutes := window.Minutes()
+	if minutes <= 0 {
+		return Progress{}, false
+	}
+
+	p := Progress{
+		Latest:              last,
+		Window:              window,
+		JobsPerMinute:       float64(last.Done+last.Failed-first.Done-first.Failed) / minutes,
+		TracksPerMinute:     float64(last.Tracks-first.Tracks) / minutes,
+		DiscoveredPerMinute: float64(last.Jobs()-first.Jobs()) / minutes,
+		FrontierGrowth:      float64(last.Pending-first.Pending) / minutes,
+	}
+	if p.FrontierGrowth < 0 {
+		p.ETA = time.Duration(float64(last.Pending) / -p.FrontierGrowth * float64(time.Minute))
+	}
+	return p, true
+}
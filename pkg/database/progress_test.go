@@ -0,0 +1,84 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEstimateProgress(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	sample := func(minutes int, pending, done, failed, tracks int64) ProgressSample {
+		return ProgressSample{
+			At:      start.Add(time.Duration(minutes) * time.Minute),
+			Pending: pending,
+			Done:    done,
+			Failed:  failed,
+			Tracks:  tracks,
+		}
+	}
+	tests := []struct {
+		name    string
+		samples []ProgressSample
+		ok      bool
+		want    Progress
+	}{
+		{
+			name: "no samples",
+		},
+		{
+			name:    "one sample",
+			samples: []ProgressSample{sample(0, 10, 0, 0, 0)},
+		},
+		{
+			name:    "no time between samples",
+			samples: []ProgressSample{sample(0, 10, 0, 0, 0), sample(0, 5, 5, 0, 0)},
+		},
+		{
+			name: "shrinking frontier",
+			samples: []ProgressSample{
+				sample(0, 700, 100, 0, 1000),
+				sample(5, 690, 150, 5, 1500),
+				sample(10, 650, 190, 10, 2000),
+			},
+			ok: true,
+			want: Progress{
+				Window:              10 * time.Minute,
+				JobsPerMinute:       10,
+				TracksPerMinute:     100,
+				DiscoveredPerMinute: 5,
+				FrontierGrowth:      -5,
+				ETA:                 130 * time.Minute,
+			},
+		},
+		{
+			name: "growing frontier has no eta",
+			samples: []ProgressSample{
+				sample(0, 100, 0, 0, 0),
+				sample(2, 140, 20, 0, 400),
+			},
+			ok: true,
+			want: Progress{
+				Window:              2 * time.Minute,
+				JobsPerMinute:       10,
+				TracksPerMinute:     200,
+				DiscoveredPerMinute: 30,
+				FrontierGrowth:      20,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := EstimateProgress(tt.samples)
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			tt.want.Latest = tt.samples[len(tt.samples)-1]
+			if got != tt.want {
+				t.Errorf("EstimateProgress =\n%+v, want\n%+v", got, tt.want)
+			}
+		})
+	}
+}
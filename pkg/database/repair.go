@@ -0,0 +1,293 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RepairReport counts what RepairJobs changed, or would change on a dry run.
+// A dry run does not apply the earlier steps, so its counts can overlap.
+type RepairReport struct {
+	// Set members stored as jobs:<id> instead of <id>
+	PrefixedMembers int
+	// jobs:jobs:<id> hashes merged into jobs:<id>
+	DoublePrefixed int
+	// jobs_working entries of jobs that were already done or failed
+	FinishedWorking int
+	// jobs_working entries returned to jobs_pending
+	StuckWorking int
+	// Job hashes that were in none of the sets
+	Orphans int
+	// Job hashes with a status that did not match their set
+	StatusFixed int
+}
+
+func (r RepairReport) Total() int {
+	return r.PrefixedMembers + r.DoublePrefixed + r.FinishedWorking + r.StuckWorking + r.Orphans + r.StatusFixed
+}
+
+var setsByPriority = []struct {
+	key    string
+	status string
+}{
+	{doneKey, "done"},
+	{failedKey, "failed"},
+	{workingKey, "working"},
+	{pendingKey, "pending"},
+}
+
+// RepairJobs brings data written by older versions in line with the job key
+// scheme. Every job in jobs_working is considered stuck, so it must only be
+// run while no scraper is running.
+func RepairJobs(rdb *redis.Client, ctx context.Context, dryRun bool) (RepairReport, error) {
+	var r RepairReport
+	var err error
+
+	r.PrefixedMembers, err = repairPrefixedMembers(rdb, ctx, dryRun)
+	if err != nil {
+		return r, err
+	}
+	r.DoublePrefixed, err = repairDoublePrefixed(rdb, ctx, dryRun)
+	if err != nil {
+		return r, err
+	}
+	r.FinishedWorking, r.StuckWorking, err = repairWorking(rdb, ctx, dryRun)
+	if err != nil {
+		return r, err
+	}
+	r.Orphans, r.StatusFixed, err = repairHashes(rdb, ctx, dryRun)
+	return r, err
+}
+
+// scanKeys calls fn for every page of keys matching pattern.
+func scanKeys(rdb *redis.Client, ctx context.Context, pattern string, fn func([]string) error) error {
+	var cursor uint64
+	for {
+		keys, next, err := rdb.Scan(ctx, cursor, pattern, 1000).Result()
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			err = fn(keys)
+			if err != nil {
+				return err
+			}
+		}
+		cursor = next
+		if cursor == 0 {
+			return nil
+		}
+	}
+}
+
+// scanMembers calls fn for every page of members of set matching pattern.
+func scanMembers(rdb *redis.Client, ctx context.Context, set string, pattern string, fn func([]string) error) error {
+	var cursor uint64
+	for {
+		members, next, err := rdb.SScan(ctx, set, cursor, pattern, 1000).Result()
+		if err != nil {
+			return err
+		}
+		if len(members) > 0 {
+			err = fn(members)
+			if err != nil {
+				return err
+			}
+		}
+		cursor = next
+		if cursor == 0 {
+			return nil
+		}
+	}
+}
+
+var errFound = errors.New("found")
+
+// NeedsRepair reports whether jobs_pending or jobs_working still hold
+// members stored as jobs:<id> by older versions. A scraper would pop those
+// as artist ids, so RepairJobs has to run first.
+func NeedsRepair(rdb *redis.Client, ctx context.Context) (bool, error) {
+	for _, key := range []string{pendingKey, workingKey} {
+		err := scanMembers(rdb, ctx, key, jobPrefix+"*", func([]string) error {
+			return errFound
+		})
+		if err == errFound {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+func repairPrefixedMembers(rdb *redis.Client, ctx context.Context, dryRun bool) (int, error) {
+	n := 0
+	for _, set := range setsByPriority {
+		// collect first, changing a set while scanning it can skip members
+		prefixed := []string{}
+		err := scanMembers(rdb, ctx, set.key, jobPrefix+"*", func(members []string) error {
+			prefixed = append(prefixed, members...)
+			return nil
+		})
+		if err != nil {
+			return n, err
+		}
+		n += len(prefixed)
+		if dryRun || len(prefixed) == 0 {
+			continue
+		}
+		pipe := rdb.TxPipeline()
+		for _, m := range prefixed {
+			pipe.SRem(ctx, set.key, m)
+			pipe.SAdd(ctx, set.key, strings.TrimPrefix(m, jobPrefix))
+		}
+		_, err = pipe.Exec(ctx)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+var mergeHashScript = redis.NewScript(`
+    local from = KEYS[1]
+    local to = KEYS[2]
+
+    local fields = redis.call("HGETALL", from)
+    for i = 1, #fields, 2 do
+        redis.call("HSETNX", to, fields[i], fields[i + 1])
+    end
+    redis.call("DEL", from)
+
+    return #fields / 2
+`)
+
+func repairDoublePrefixed(rdb *redis.Client, ctx context.Context, dryRun bool) (int, error) {
+	keys := []string{}
+	err := scanKeys(rdb, ctx, jobPrefix+jobPrefix+"*", func(page []string) error {
+		keys = append(keys, page...)
+		return nil
+	})
+	if err != nil || dryRun {
+		return len(keys), err
+	}
+	for _, key := range keys {
+		// fields already on the correct hash win
+		err = mergeHashScript.Run(ctx, rdb, []string{key, strings.TrimPrefix(key, jobPrefix)}).Err()
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(keys), nil
+}
+
+func repairWorking(rdb *redis.Client, ctx context.Context, dryRun bool) (int, int, error) {
+	working, err := rdb.SMembers(ctx, workingKey).Result()
+	if err != nil || len(working) == 0 {
+		return 0, 0, err
+	}
+
+	pipe := rdb.Pipeline()
+	inDone := make([]*redis.BoolCmd, len(working))
+	inFailed := make([]*redis.BoolCmd, len(working))
+	for i, job := range working {
+		inDone[i] = pipe.SIsMember(ctx, doneKey, job)
+		inFailed[i] = pipe.SIsMember(ctx, failedKey, job)
+	}
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	finished := 0
+	stuck := []string{}
+	tx := rdb.TxPipeline()
+	for i, job := range working {
+		if inDone[i].Val() || inFailed[i].Val() {
+			finished++
+			tx.SRem(ctx, workingKey, job)
+			continue
+		}
+		stuck = append(stuck, job)
+		tx.SMove(ctx, workingKey, pendingKey, job)
+		tx.HSet(ctx, jobKey(job), "status", "pending")
+	}
+	if dryRun {
+		return finished, len(stuck), nil
+	}
+	_, err = tx.Exec(ctx)
+	if err != nil {
+		return finished, len(stuck), err
+	}
+	_, err = releaseJobClaims(rdb, ctx, stuck)
+	return finished, len(stuck), err
+}
+
+func repairHashes(rdb *redis.Client, ctx context.Context, dryRun bool) (int, int, error) {
+	orphans := 0
+	fixed := 0
+	err := scanKeys(rdb, ctx, jobPrefix+"*", func(keys []string) error {
+		pipe := rdb.Pipeline()
+		type check struct {
+			id      string
+			status  *redis.StringCmd
+			members []*redis.BoolCmd
+		}
+		checks := []check{}
+		for _, key := range keys {
+			id := strings.TrimPrefix(key, jobPrefix)
+			if strings.HasPrefix(id, jobPrefix) {
+				// only left over on a dry run
+				continue
+			}
+			c := check{id: id, status: pipe.HGet(ctx, key, "status")}
+			for _, set := range setsByPriority {
+				c.members = append(c.members, pipe.SIsMember(ctx, set.key, id))
+			}
+			checks = append(checks, c)
+		}
+		_, err := pipe.Exec(ctx)
+		if err != nil && err != redis.Nil {
+			return err
+		}
+
+		tx := rdb.TxPipeline()
+		for _, c := range checks {
+			status := c.status.Val()
+			want := ""
+			for i, set := range setsByPriority {
+				if c.members[i].Val() {
+					want = set.status
+					break
+				}
+			}
+			if want == "" {
+				orphans++
+				want = "pending"
+				set := pendingKey
+				switch status {
+				case "done":
+					want, set = "done", doneKey
+				case "failed":
+					want, set = "failed", failedKey
+				}
+				tx.SAdd(ctx, set, c.id)
+			} else if status != want {
+				fixed++
+			}
+			if status != want {
+				tx.HSet(ctx, jobKey(c.id), "status", want)
+			}
+		}
+		if dryRun {
+			return nil
+		}
+		_, err = tx.Exec(ctx)
+		return err
+	})
+	return orphans, fixed, err
+}
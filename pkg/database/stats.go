@@ -0,0 +1,35 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	spt "github.com/Pineapple217/MetaRaid/pkg/spotify"
+	"github.com/redis/go-redis/v9"
+)
+
+const statsRetention = 30 * 24 * time.Hour
+
+// SaveRequestStats adds the request counters of a key to the hash of the
+// minute they were collected in, stats:requests:<key>:<yyyymmddhhmm>.
+func SaveRequestStats(rdb *redis.Client, ctx context.Context, key string, at time.Time, stats map[string]spt.EndpointStats) error {
+	if len(stats) == 0 {
+		return nil
+	}
+	hashKey := fmt.Sprintf("stats:requests:%s:%s", key, at.UTC().Format("200601021504"))
+
+	pipe := rdb.TxPipeline()
+	for name, e := range stats {
+		pipe.HIncrBy(ctx, hashKey, name+":requests", e.Requests)
+		pipe.HIncrBy(ctx, hashKey, name+":latency_ms", e.Latency.Milliseconds())
+		pipe.HIncrBy(ctx, hashKey, name+":retry_wait_ms", e.RetryWait.Milliseconds())
+		for code, n := range e.Status {
+			pipe.HIncrBy(ctx, hashKey, fmt.Sprintf("%s:status:%d", name, code), n)
+		}
+	}
+	pipe.Expire(ctx, hashKey, statsRetention)
+
+	_, err := pipe.Exec(ctx)
+	return err
+}
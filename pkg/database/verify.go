@@ -0,0 +1,167 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type ProblemKind string
+
+const (
+	// The job is in more than one of the state sets
+	Duplicate ProblemKind = "duplicate"
+	// The job hash is in none of the state sets
+	Orphan ProblemKind = "orphan"
+	// The status of the job hash does not match its set
+	StatusMismatch ProblemKind = "status_mismatch"
+	// The job is in a set but has no hash
+	MissingHash ProblemKind = "missing_hash"
+	// The job is done but no tracks were stored or skipped for it
+	EmptyDone ProblemKind = "empty_done"
+)
+
+type Problem struct {
+	Job    string
+	Kind   ProblemKind
+	Detail string
+	// Status the job should get, empty when there is no safe fix
+	Fix string
+}
+
+type VerifyReport struct {
+	Jobs     int
+	Problems []Problem
+}
+
+// VerifyJobs walks all job state with SCAN and reports every job whose sets
+// and hash contradict each other. A done job without a "tracks" field was
+// done before tracks were counted per job, its count is unknown and it is
+// not reported as empty. Neither is a job that stored nothing because other
+// jobs already fetched its albums and tracks.
+func VerifyJobs(rdb *redis.Client, ctx context.Context) (VerifyReport, error) {
+	var r VerifyReport
+
+	missing := make(map[string]int)
+	for _, set := range setsByPriority {
+		err := scanMembers(rdb, ctx, set.key, "*", func(members []string) error {
+			pipe := rdb.Pipeline()
+			exists := make([]*redis.IntCmd, len(members))
+			for i, id := range members {
+				exists[i] = pipe.Exists(ctx, jobKey(id))
+			}
+			_, err := pipe.Exec(ctx)
+			if err != nil {
+				return err
+			}
+			for i, id := range members {
+				if exists[i].Val() != 0 {
+					continue
+				}
+				// sets are walked by priority, the first one is kept
+				if j, ok := missing[id]; ok {
+					r.Problems[j].Kind = Duplicate
+					r.Problems[j].Detail += ", " + set.key
+					continue
+				}
+				missing[id] = len(r.Problems)
+				r.Problems = append(r.Problems, Problem{
+					Job:    id,
+					Kind:   MissingHash,
+					Detail: "in " + set.key,
+					Fix:    set.status,
+				})
+			}
+			return nil
+		})
+		if err != nil {
+			return r, err
+		}
+	}
+
+	err := scanKeys(rdb, ctx, jobPrefix+"*", func(keys []string) error {
+		pipe := rdb.Pipeline()
+		fields := make([]*redis.SliceCmd, len(keys))
+		members := make([][]*redis.BoolCmd, len(keys))
+		for i, key := range keys {
+			id := strings.TrimPrefix(key, jobPrefix)
+			fields[i] = pipe.HMGet(ctx, key, "status", "tracks", "skipped")
+			for _, set := range setsByPriority {
+				members[i] = append(members[i], pipe.SIsMember(ctx, set.key, id))
+			}
+		}
+		_, err := pipe.Exec(ctx)
+		if err != nil {
+			return err
+		}
+
+		for i, key := range keys {
+			r.Jobs++
+			id := strings.TrimPrefix(key, jobPrefix)
+			status, _ := fields[i].Val()[0].(string)
+			tracks, _ := fields[i].Val()[1].(string)
+			skipped, _ := fields[i].Val()[2].(string)
+
+			in := []string{}
+			want := ""
+			for j, set := range setsByPriority {
+				if members[i][j].Val() {
+					in = append(in, set.key)
+					if want == "" {
+						want = set.status
+					}
+				}
+			}
+
+			switch {
+			case len(in) == 0:
+				fix := "pending"
+				if status == "done" || status == "failed" {
+					fix = status
+				}
+				r.Problems = append(r.Problems, Problem{Job: id, Kind: Orphan, Detail: "status " + status, Fix: fix})
+			case len(in) > 1:
+				r.Problems = append(r.Problems, Problem{Job: id, Kind: Duplicate, Detail: strings.Join(in, ", "), Fix: want})
+			case status != want:
+				r.Problems = append(r.Problems, Problem{Job: id, Kind: StatusMismatch, Detail: "status " + status + " in " + in[0], Fix: want})
+			case want == "done" && tracks == "0" && (skipped == "" || skipped == "0"):
+				r.Problems = append(r.Problems, Problem{Job: id, Kind: EmptyDone, Detail: "no tracks stored or skipped"})
+			}
+		}
+		return nil
+	})
+	return r, err
+}
+
+var fixJobScript = redis.NewScript(`
+    local job = ARGV[1]
+    local status = ARGV[2]
+    local target = ARGV[3]
+
+    for i, setKey in ipairs(KEYS) do
+        redis.call("SREM", setKey, job)
+    end
+    redis.call("SADD", target, job)
+    redis.call("HSET", "jobs:" .. job, "status", status)
+
+    return 1
+`)
+
+// FixJob atomically moves a job into exactly one state set and sets the
+// status of its hash to match.
+func FixJob(rdb *redis.Client, ctx context.Context, job string, status string) error {
+	target := ""
+	keys := make([]string, len(setsByPriority))
+	for i, set := range setsByPriority {
+		keys[i] = set.key
+		if set.status == status {
+			target = set.key
+		}
+	}
+	if target == "" {
+		return fmt.Errorf("unknown job status %q", status)
+	}
+	return fixJobScript.Run(ctx, rdb, keys, job, status, target).Err()
+}
@@ -0,0 +1,134 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Pineapple217/MetaRaid/pkg/config"
+)
+
+type Event string
+
+const (
+	AllWorkersStopped Event = "all_workers_stopped"
+	KeyCold           Event = "key_cold"
+	QueueEmpty        Event = "queue_empty"
+	ErrorRateSpike    Event = "error_rate_spike"
+	CrawlFinished     Event = "crawl_finished"
+)
+
+// Notification is the JSON body posted to the webhooks.
+type Notification struct {
+	Event   Event     `json:"event"`
+	Message string    `json:"message"`
+	Key     string    `json:"key,omitempty"`
+	Details any       `json:"details,omitempty"`
+	At      time.Time `json:"at"`
+}
+
+// Notifier posts notifications to the configured webhooks in the background.
+// Notifications with the same event and key are only sent once per dedup
+// window.
+type Notifier struct {
+	conf   config.Webhook
+	client *http.Client
+	wg     sync.WaitGroup
+
+	mu   sync.Mutex
+	sent map[string]time.Time
+}
+
+func New(conf config.Webhook) *Notifier {
+	return &Notifier{
+		conf:   conf,
+		client: &http.Client{Timeout: conf.Timeout},
+		sent:   make(map[string]time.Time),
+	}
+}
+
+// Notify sends nt unless an equal notification was sent within the dedup
+// window. It does not block, a nil Notifier drops everything.
+func (n *Notifier) Notify(nt Notification) {
+	if n == nil || len(n.conf.Urls) == 0 {
+		return
+	}
+	if nt.At.IsZero() {
+		nt.At = time.Now()
+	}
+
+	id := string(nt.Event) + ":" + nt.Key
+	n.mu.Lock()
+	last, ok := n.sent[id]
+	if ok && nt.At.Sub(last) < n.conf.Dedup {
+		n.mu.Unlock()
+		slog.Debug("Skipping duplicate notification", "event", nt.Event, "key", nt.Key)
+		return
+	}
+	n.sent[id] = nt.At
+	n.mu.Unlock()
+
+	body, err := json.Marshal(nt)
+	if err != nil {
+		slog.Warn("Failed to encode notification", "event", nt.Event, "error", err)
+		return
+	}
+	for _, url := range n.conf.Urls {
+		n.wg.Add(1)
+		go func() {
+			defer n.wg.Done()
+			err := n.post(url, body)
+			if err != nil {
+				slog.Warn("Failed to send notification", "event", nt.Event, "url", url, "error", err)
+			}
+		}()
+	}
+}
+
+// post sends body to url, retrying network errors and 5xx responses.
+func (n *Notifier) post(url string, body []byte) error {
+	var err error
+	backoff := time.Second
+	for attempt := range max(n.conf.Retries, 1) {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		var resp *http.Response
+		resp, err = n.client.Post(url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 300 {
+			return nil
+		}
+		err = fmt.Errorf("unexpected status %s", resp.Status)
+		if resp.StatusCode < 500 {
+			return err
+		}
+	}
+	return err
+}
+
+// Close waits until the notifications in flight are sent or ctx is done.
+func (n *Notifier) Close(ctx context.Context) {
+	if n == nil {
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		n.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		slog.Warn("Timed out sending notifications")
+	}
+}
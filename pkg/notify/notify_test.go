@@ -0,0 +1,112 @@
+package notify
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Pineapple217/MetaRaid/pkg/config"
+)
+
+func newTestNotifier(url string) *Notifier {
+	var conf config.Webhook
+	conf.SetDefault()
+	conf.Urls = []string{url}
+	conf.Retries = 2
+	conf.Timeout = time.Second
+	return New(conf)
+}
+
+// waitClose closes n and fails the test when posts are still in flight
+// after a few seconds.
+func waitClose(t *testing.T, n *Notifier) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		n.Close(context.Background())
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close did not return")
+	}
+}
+
+func TestRetriesServerErrors(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer srv.Close()
+
+	n := newTestNotifier(srv.URL)
+	err := n.post(srv.URL, []byte("{}"))
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	if got := calls.Load(); got != 2 {
+		t.Errorf("calls = %d, want 2", got)
+	}
+}
+
+func TestDoesNotRetryClientErrors(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	n := newTestNotifier(srv.URL)
+	err := n.post(srv.URL, []byte("{}"))
+	if err == nil {
+		t.Fatal("post: want an error for a 400")
+	}
+	if got := calls.Load(); got != 1 {
+		t.Errorf("calls = %d, want 1", got)
+	}
+}
+
+func TestDedup(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+	}))
+	defer srv.Close()
+
+	n := newTestNotifier(srv.URL)
+	now := time.Now()
+	n.Notify(Notification{Event: KeyCold, Key: "a", At: now})
+	n.Notify(Notification{Event: KeyCold, Key: "a", At: now.Add(time.Minute)})
+	n.Notify(Notification{Event: KeyCold, Key: "b", At: now})
+	n.Notify(Notification{Event: QueueEmpty, Key: "a", At: now})
+	n.Notify(Notification{Event: KeyCold, Key: "a", At: now.Add(n.conf.Dedup)})
+	waitClose(t, n)
+
+	if got := calls.Load(); got != 4 {
+		t.Errorf("calls = %d, want 4", got)
+	}
+}
+
+func TestCloseWaitsForPosts(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		calls.Add(1)
+	}))
+	defer srv.Close()
+
+	n := newTestNotifier(srv.URL)
+	n.Notify(Notification{Event: CrawlFinished})
+	waitClose(t, n)
+
+	if got := calls.Load(); got != 1 {
+		t.Errorf("calls after Close = %d, want 1", got)
+	}
+}
@@ -0,0 +1,111 @@
+package scraper
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/Pineapple217/MetaRaid/pkg/database"
+	spt "github.com/Pineapple217/MetaRaid/pkg/spotify"
+	"github.com/zmb3/spotify/v2"
+)
+
+// startAnalysis starts the audio analysis stage of c when it has a budget,
+// the caller adds it to s.Clients. The first key also starts the poller that
+// feeds all of them, so the backlog only ever holds one Redis connection.
+func (s *Scraper) startAnalysis(c *spt.Client) {
+	if !c.AnalysisEnabled() {
+		return
+	}
+	s.analysisOnce.Do(func() {
+		s.Wg.Add(1)
+		go s.pollAnalysis()
+	})
+	s.Wg.Add(1)
+	go s.analyzeTracks(c)
+}
+
+// pollAnalysis takes tracks from the audio analysis backlog and hands them
+// to the first key that is ready, until the scraper stops.
+func (s *Scraper) pollAnalysis() {
+	defer s.Wg.Done()
+	ctx := context.Background()
+	for s.ctx.Err() == nil {
+		id, err := database.PopAnalysis(s.RDB, ctx, 5*time.Second)
+		if err != nil {
+			slog.Warn("failed to get track for audio analysis", "error", err)
+			select {
+			case <-s.ctx.Done():
+			case <-time.After(5 * time.Second):
+			}
+			continue
+		}
+		if id == "" {
+			continue
+		}
+		select {
+		case s.analysisIds <- id:
+		case <-s.ctx.Done():
+			err = database.RequeueAnalysis(s.RDB, ctx, id)
+			if err != nil {
+				slog.Error("Failed to requeue audio analysis", "track", id, "error", err)
+			}
+		}
+	}
+}
+
+// analyzeTracks analyses the tracks handed out by pollAnalysis within the
+// budget of c, until c is retired or the scraper stops.
+func (s *Scraper) analyzeTracks(c *spt.Client) {
+	defer s.Wg.Done()
+	logger := slog.With("key", c.Name)
+	logger.Info("Starting audio analysis")
+	defer logger.Info("stopped audio analysis")
+	ctx := context.Background()
+
+	for s.ctx.Err() == nil && slices.Contains(s.clientList(), c) {
+		if !c.IsAvailable() {
+			select {
+			case <-s.ctx.Done():
+			case <-time.After(c.CooldownRemaining() + 5*time.Second):
+			}
+			continue
+		}
+		var id string
+		select {
+		case id = <-s.analysisIds:
+		case <-s.ctx.Done():
+			continue
+		case <-time.After(5 * time.Second):
+			// check again whether c was retired
+			continue
+		}
+
+		a, err := c.FetchAnalysis(s.ctx, spotify.ID(id))
+		var maxErr *spotify.MaxRetryDurationExceededErr
+		if errors.As(err, &maxErr) || s.ctx.Err() != nil {
+			if maxErr != nil {
+				c.UpdateStatus(maxErr)
+			}
+			err = database.RequeueAnalysis(s.RDB, ctx, id)
+			if err != nil {
+				logger.Error("Failed to requeue audio analysis", "track", id, "error", err)
+			}
+			continue
+		}
+		if err != nil {
+			logger.Debug("failed to fetch audio analysis", "track", id, "error", err)
+			err = database.DropAnalysis(s.RDB, ctx, id)
+			if err != nil {
+				logger.Warn("Failed to drop audio analysis", "track", id, "error", err)
+			}
+			continue
+		}
+		err = database.SetTrackAnalysis(s.RDB, ctx, id, a)
+		if err != nil {
+			logger.Warn("Failed to store audio analysis", "track", id, "error", err)
+		}
+	}
+}
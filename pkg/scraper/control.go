@@ -0,0 +1,193 @@
+package scraper
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var (
+	ErrWorkerNotFound = errors.New("worker not found")
+	ErrWorkerIdle     = errors.New("worker has no job")
+	ErrLastWorker     = errors.New("pool must keep at least 1 worker")
+)
+
+// gate holds the paused state of a worker or the job fetcher, the returned
+// channel is closed on the next change so they can block on it.
+type gate struct {
+	mu      sync.Mutex
+	paused  bool
+	changed chan struct{}
+}
+
+func newGate(paused bool) *gate {
+	return &gate{paused: paused, changed: make(chan struct{})}
+}
+
+func (g *gate) set(paused bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.paused == paused {
+		return
+	}
+	g.paused = paused
+	close(g.changed)
+	g.changed = make(chan struct{})
+}
+
+func (g *gate) state() (bool, <-chan struct{}) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.paused, g.changed
+}
+
+func (g *gate) isPaused() bool {
+	p, _ := g.state()
+	return p
+}
+
+type WorkerInfo struct {
+	Id     string `json:"id"`
+	Key    string `json:"key"`
+	Status string `json:"status"`
+	// When the worker entered its current status
+	Since time.Time `json:"since"`
+	// When the worker entered each status it has been in
+	Transitions map[string]time.Time `json:"transitions"`
+	Paused      bool                 `json:"paused"`
+	Busy        bool                 `json:"busy"`
+	// Current job and when it started, empty when idle
+	Job      string     `json:"job,omitempty"`
+	JobStart *time.Time `json:"job_start,omitempty"`
+	Stalled  bool       `json:"stalled"`
+}
+
+func (w *Worker) Info() WorkerInfo {
+	w.mu.Lock()
+	transitions := make(map[string]time.Time, len(w.statusTimes))
+	for st, t := range w.statusTimes {
+		transitions[st.String()] = t
+	}
+	info := WorkerInfo{
+		Id:          w.id,
+		Key:         w.client.Name,
+		Status:      w.status.String(),
+		Since:       w.statusTimes[w.status],
+		Transitions: transitions,
+		Job:         w.job,
+	}
+	if w.job != "" {
+		start := w.jobStart
+		info.JobStart = &start
+	}
+	w.mu.Unlock()
+	info.Paused = w.pause.isPaused()
+	info.Busy = w.busy.Load()
+	return info
+}
+
+// Pause stops the worker from taking new jobs. The current job is finished,
+// or with abort cancelled and returned to the pending queue.
+func (w *Worker) Pause(abort bool) {
+	w.logger.Info("pausing", "abort", abort)
+	w.pause.set(true)
+	if abort {
+		w.abortJob()
+	}
+}
+
+func (w *Worker) Resume() {
+	w.logger.Info("resuming")
+	w.pause.set(false)
+}
+
+func (s *Scraper) Workers() []WorkerInfo {
+	ws := s.workerList()
+	out := make([]WorkerInfo, len(ws))
+	for i, w := range ws {
+		out[i] = w.Info()
+		out[i].Stalled = w.stalled(s.Config.StallTimeout)
+	}
+	return out
+}
+
+func (s *Scraper) worker(id string) (*Worker, error) {
+	for _, w := range s.workerList() {
+		if w.id == id {
+			return w, nil
+		}
+	}
+	return nil, ErrWorkerNotFound
+}
+
+func (s *Scraper) PauseWorker(id string, abort bool) error {
+	w, err := s.worker(id)
+	if err != nil {
+		return err
+	}
+	w.Pause(abort)
+	return nil
+}
+
+func (s *Scraper) ResumeWorker(id string) error {
+	w, err := s.worker(id)
+	if err != nil {
+		return err
+	}
+	w.Resume()
+	return nil
+}
+
+// Pause stops the job fetcher and all workers. Jobs already fetched stay in
+// Jobs so Resume continues from the same queue state.
+func (s *Scraper) Pause(abort bool) {
+	slog.Info("Pausing all workers", "abort", abort)
+	s.pause.set(true)
+	for _, w := range s.workerList() {
+		w.Pause(abort)
+	}
+}
+
+func (s *Scraper) Resume() {
+	slog.Info("Resuming all workers")
+	for _, w := range s.workerList() {
+		w.Resume()
+	}
+	s.pause.set(false)
+}
+
+func (s *Scraper) Paused() bool {
+	return s.pause.isPaused()
+}
+
+// Drain pauses all workers and shuts the scraper down once their current
+// jobs are done.
+func (s *Scraper) Drain() {
+	slog.Info("Draining worker pool")
+	s.Pause(false)
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-ticker.C:
+				busy := 0
+				for _, w := range s.workerList() {
+					if w.busy.Load() {
+						busy++
+					}
+				}
+				if busy == 0 {
+					slog.Info("Worker pool drained")
+					syscall.Kill(os.Getpid(), syscall.SIGINT)
+					return
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,69 @@
+package scraper
+
+import (
+	"context"
+	"time"
+)
+
+type Health struct {
+	Redis          string `json:"redis"`
+	RunningWorkers int    `json:"running_workers"`
+	PoolSize       int    `json:"pool_size"`
+	ColdKeys       int    `json:"cold_keys"`
+	Keys           int    `json:"keys"`
+	Paused         bool   `json:"paused"`
+	// Jobs finished in the previous minute
+	JobsPerMinute int64     `json:"jobs_per_minute"`
+	LastJob       time.Time `json:"last_job"`
+}
+
+// Health returns the state used by the health and readiness checks.
+func (s *Scraper) Health(ctx context.Context) Health {
+	h := Health{
+		Redis:         "ok",
+		PoolSize:      s.PoolSize(),
+		Paused:        s.Paused(),
+		JobsPerMinute: s.counts.lastMinute.Load(),
+		LastJob:       time.Unix(0, s.counts.last.Load()),
+	}
+	err := s.RDB.Ping(ctx).Err()
+	if err != nil {
+		h.Redis = err.Error()
+	}
+	for _, w := range s.workerList() {
+		if w.getStatus() == running {
+			h.RunningWorkers++
+		}
+	}
+	for _, c := range s.clientList() {
+		h.Keys++
+		if !c.IsAvailable() {
+			h.ColdKeys++
+		}
+	}
+	return h
+}
+
+// Alive reports whether the scraper is making progress: redis is reachable
+// and, unless paused, a job finished within the stall timeout or no worker
+// is busy.
+func (s *Scraper) Alive(h Health) bool {
+	if h.Redis != "ok" {
+		return false
+	}
+	if h.Paused || s.Config.StallTimeout <= 0 || time.Since(h.LastJob) < s.Config.StallTimeout {
+		return true
+	}
+	for _, w := range s.workerList() {
+		if w.busy.Load() {
+			return false
+		}
+	}
+	return true
+}
+
+// Ready reports whether the scraper can take on work: redis is reachable,
+// it is not paused and at least one worker is running.
+func (s *Scraper) Ready(h Health) bool {
+	return h.Redis == "ok" && !h.Paused && h.RunningWorkers > 0
+}
@@ -0,0 +1,88 @@
+package scraper
+
+import (
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/Pineapple217/MetaRaid/pkg/database"
+	"github.com/Pineapple217/MetaRaid/pkg/notify"
+)
+
+// jobCounts counts the outcome of jobs, shared by all workers.
+type jobCounts struct {
+	done   atomic.Int64
+	failed atomic.Int64
+	// unix nano of the last finished job
+	last atomic.Int64
+	// jobs finished in the previous minute
+	lastMinute atomic.Int64
+}
+
+func (c *jobCounts) finished(failed bool) {
+	if failed {
+		c.failed.Add(1)
+	} else {
+		c.done.Add(1)
+	}
+	c.last.Store(time.Now().UnixNano())
+}
+
+// incidents remembers what was notified so changes are only reported once.
+type incidents struct {
+	queueEmpty bool
+	finished   bool
+}
+
+// checkQueue notifies when the pending queue runs empty and when, on top of
+// that, no job is being worked on anymore.
+func (s *Scraper) checkQueue(in *incidents) {
+	sizes, err := database.GetQueueSizes(s.RDB, s.ctx)
+	if err != nil {
+		slog.Warn("failed to get queue sizes", "error", err)
+		return
+	}
+	empty := sizes.Pending == 0 && len(s.Jobs) == 0
+	if empty && !in.queueEmpty {
+		s.notifier.Notify(notify.Notification{
+			Event:   notify.QueueEmpty,
+			Message: "The pending job queue is empty",
+			Details: sizes,
+		})
+	}
+	if empty && sizes.Working == 0 && !in.finished {
+		s.notifier.Notify(notify.Notification{
+			Event:   notify.CrawlFinished,
+			Message: fmt.Sprintf("Crawl finished, %d jobs done and %d failed", sizes.Done, sizes.Failed),
+			Details: sizes,
+		})
+		in.finished = true
+	}
+	in.queueEmpty = empty
+	if !empty {
+		in.finished = false
+	}
+}
+
+// checkErrorRate resets the job counts and notifies when the share of failed
+// jobs since the last call is above the configured error rate.
+func (s *Scraper) checkErrorRate() {
+	done := s.counts.done.Swap(0)
+	failed := s.counts.failed.Swap(0)
+	total := done + failed
+	s.counts.lastMinute.Store(total)
+	if total == 0 || total < int64(s.webhook.ErrorRateMinJobs) {
+		return
+	}
+	rate := float64(failed) / float64(total)
+	if rate < s.webhook.ErrorRate {
+		return
+	}
+	slog.Warn("Job error rate spike", "failed", failed, "total", total)
+	s.notifier.Notify(notify.Notification{
+		Event:   notify.ErrorRateSpike,
+		Message: fmt.Sprintf("%d of %d jobs failed in the last minute", failed, total),
+		Details: map[string]any{"done": done, "failed": failed, "rate": rate},
+	})
+}
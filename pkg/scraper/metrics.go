@@ -0,0 +1,103 @@
+package scraper
+
+import (
+	"log/slog"
+
+	"github.com/Pineapple217/MetaRaid/pkg/database"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	tracksInserted = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "metaraid",
+		Name:      "tracks_inserted_total",
+		Help:      "Number of tracks stored in Redis.",
+	})
+	requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "metaraid",
+		Name:      "spotify_requests_total",
+		Help:      "Number of requests sent to Spotify, updated every minute.",
+	}, []string{"key", "endpoint"})
+	jobDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "metaraid",
+		Name:      "job_duration_seconds",
+		Help:      "Time it took to scrape a single artist.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 12),
+	}, []string{"key"})
+	workersRetired = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "metaraid",
+		Name:      "workers_retired_total",
+		Help:      "Number of workers stopped, by key and the state they stopped from.",
+	}, []string{"key", "from"})
+
+	jobsDesc = prometheus.NewDesc(
+		"metaraid_jobs",
+		"Number of jobs per queue state.",
+		[]string{"state"}, nil,
+	)
+	workersDesc = prometheus.NewDesc(
+		"metaraid_workers",
+		"Number of live workers per state, stopped workers are dropped from the pool and counted by metaraid_workers_retired_total.",
+		[]string{"state"}, nil,
+	)
+	stalledDesc = prometheus.NewDesc(
+		"metaraid_stalled_jobs",
+		"Number of jobs running longer than the stall timeout.",
+		nil, nil,
+	)
+	cooldownDesc = prometheus.NewDesc(
+		"metaraid_key_cooldown_seconds",
+		"Time until a cold key can be used again.",
+		[]string{"key"}, nil,
+	)
+)
+
+// Describe implements prometheus.Collector for the metrics that are read
+// on every scrape.
+func (s *Scraper) Describe(ch chan<- *prometheus.Desc) {
+	ch <- jobsDesc
+	ch <- workersDesc
+	ch <- stalledDesc
+	ch <- cooldownDesc
+}
+
+// Collect implements prometheus.Collector.
+func (s *Scraper) Collect(ch chan<- prometheus.Metric) {
+	sizes, err := database.GetQueueSizes(s.RDB, s.ctx)
+	if err != nil {
+		slog.Warn("failed to get queue sizes", "error", err)
+	} else {
+		ch <- prometheus.MustNewConstMetric(jobsDesc, prometheus.GaugeValue, float64(sizes.Pending), "pending")
+		ch <- prometheus.MustNewConstMetric(jobsDesc, prometheus.GaugeValue, float64(sizes.Working), "working")
+		ch <- prometheus.MustNewConstMetric(jobsDesc, prometheus.GaugeValue, float64(sizes.Done), "done")
+		ch <- prometheus.MustNewConstMetric(jobsDesc, prometheus.GaugeValue, float64(sizes.Failed), "failed")
+	}
+
+	states := map[status]int{initialized: 0, running: 0}
+	stalled := 0
+	for _, w := range s.workerList() {
+		if st := w.getStatus(); st == initialized || st == running {
+			states[st]++
+		}
+		if w.stalled(s.Config.StallTimeout) {
+			stalled++
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(stalledDesc, prometheus.GaugeValue, float64(stalled))
+	for st, n := range states {
+		ch <- prometheus.MustNewConstMetric(workersDesc, prometheus.GaugeValue, float64(n), st.String())
+	}
+
+	for _, c := range s.clientList() {
+		ch <- prometheus.MustNewConstMetric(cooldownDesc, prometheus.GaugeValue, c.CooldownRemaining().Seconds(), c.Name)
+	}
+}
+
+// countRetired counts workers that stopped, a worker of a cold key stops
+// from coldKey.
+func countRetired(t Transition) {
+	if t.To == stopped.String() {
+		workersRetired.WithLabelValues(t.Key, t.From).Inc()
+	}
+}
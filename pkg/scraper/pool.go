@@ -0,0 +1,174 @@
+package scraper
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/Pineapple217/MetaRaid/pkg/database"
+	spt "github.com/Pineapple217/MetaRaid/pkg/spotify"
+)
+
+// workerList returns a snapshot of the current workers.
+func (s *Scraper) workerList() []*Worker {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return slices.Clone(s.workers)
+}
+
+// clientList returns a snapshot of the current clients.
+func (s *Scraper) clientList() []*spt.Client {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return slices.Clone(s.Clients)
+}
+
+// startWorker creates and starts a worker for c, s.mu must be held.
+func (s *Scraper) startWorker(c *spt.Client) {
+	id := strconv.Itoa(s.nextId)
+	s.nextId++
+	logger := slog.With(slog.Group("worker"), slog.String("id", id), slog.String("key", c.Name))
+	workerCtx, workerCancel := context.WithCancel(s.ctx)
+	w := &Worker{
+		client: c,
+		id:     id,
+		logger: logger,
+		rdb:    s.RDB,
+		index:  s.index,
+		ctx:    workerCtx,
+		cancel: workerCancel,
+		pause:  newGate(s.pause.isPaused()),
+		notify: s.notify,
+
+		notifier: s.notifier,
+		counts:   s.counts,
+
+		status:      initialized,
+		statusTimes: map[status]time.Time{initialized: time.Now()},
+	}
+	s.workers = append(s.workers, w)
+	s.Wg.Add(1)
+	w.Start(&s.Wg, s.Jobs)
+}
+
+// balance drops stopped workers, stops the workers of cold or retired keys
+// and then starts or stops workers until the pool has poolSize workers,
+// spread evenly over the available keys. Workers on the same key share its
+// rate limiter.
+func (s *Scraper) balance() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	perKey := make(map[*spt.Client][]*Worker)
+	available := []*spt.Client{}
+	for _, c := range s.Clients {
+		if c.IsAvailable() {
+			available = append(available, c)
+			perKey[c] = nil
+		}
+	}
+
+	active := []*Worker{}
+	for _, w := range s.workers {
+		if w.getStatus() == stopped {
+			continue
+		}
+		if _, ok := perKey[w.client]; !ok {
+			w.logger.Info("key is unavailable, stopping worker")
+			w.Stop()
+			continue
+		}
+		perKey[w.client] = append(perKey[w.client], w)
+		active = append(active, w)
+	}
+
+	for len(active) > s.poolSize {
+		// stop an idle worker of the busiest key, if there is one
+		var c *spt.Client
+		for _, k := range available {
+			if c == nil || len(perKey[k]) > len(perKey[c]) {
+				c = k
+			}
+		}
+		ws := perKey[c]
+		i := slices.IndexFunc(ws, func(w *Worker) bool { return !w.busy.Load() })
+		if i == -1 {
+			i = len(ws) - 1
+		}
+		w := ws[i]
+		w.Stop()
+		perKey[c] = slices.Delete(ws, i, i+1)
+		active = slices.DeleteFunc(active, func(a *Worker) bool { return a == w })
+	}
+	s.workers = active
+
+	for len(s.workers) < s.poolSize && len(available) > 0 {
+		var c *spt.Client
+		for _, k := range available {
+			if c == nil || len(perKey[k]) < len(perKey[c]) {
+				c = k
+			}
+		}
+		s.startWorker(c)
+		perKey[c] = append(perKey[c], s.workers[len(s.workers)-1])
+	}
+}
+
+func (s *Scraper) PoolSize() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.poolSize
+}
+
+// Scale changes the number of workers in the pool.
+func (s *Scraper) Scale(n int) {
+	slog.Info("Scaling worker pool", "size", n)
+	s.mu.Lock()
+	s.poolSize = n
+	s.mu.Unlock()
+	s.balance()
+}
+
+// RemoveWorker stops a single worker and shrinks the pool by one. Like Scale
+// it does not shrink the pool below 1 worker.
+func (s *Scraper) RemoveWorker(id string) error {
+	s.mu.Lock()
+	i := slices.IndexFunc(s.workers, func(w *Worker) bool { return w.id == id })
+	if i == -1 {
+		s.mu.Unlock()
+		return ErrWorkerNotFound
+	}
+	if s.poolSize <= 1 {
+		s.mu.Unlock()
+		return ErrLastWorker
+	}
+	s.workers[i].Stop()
+	s.workers = slices.Delete(s.workers, i, i+1)
+	s.poolSize--
+	s.mu.Unlock()
+	return nil
+}
+
+// warmUp probes cold keys whose cooldown has passed so balance can use them
+// again.
+func (s *Scraper) warmUp() {
+	for _, c := range s.clientList() {
+		if c.IsAvailable() || c.CooldownRemaining() > 0 {
+			continue
+		}
+		err := c.Probe(s.ctx)
+		if err != nil {
+			slog.Warn("Failed to probe key", "name", c.Name, "error", err)
+			continue
+		}
+		if c.IsAvailable() {
+			slog.Info("Key recovered", "name", c.Name)
+			err = database.PublishEvent(s.RDB, s.ctx, database.Event{Type: database.KeyWarm, Key: c.Name})
+			if err != nil {
+				slog.Warn("Failed to publish event", "error", err)
+			}
+		}
+	}
+}
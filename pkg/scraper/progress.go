@@ -0,0 +1,44 @@
+package scraper
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/Pineapple217/MetaRaid/pkg/database"
+)
+
+// progressWindow is the time the logged rates are based on.
+const progressWindow = time.Hour
+
+// trackProgress stores a progress sample and logs the rates and time to
+// exhaustion estimated from the samples of the last progressWindow.
+func (s *Scraper) trackProgress() {
+	sample, err := database.SampleProgress(s.RDB, s.ctx)
+	if err != nil {
+		slog.Warn("failed to sample progress", "error", err)
+		return
+	}
+	err = database.SaveProgressSample(s.RDB, s.ctx, sample)
+	if err != nil {
+		slog.Warn("failed to save progress", "error", err)
+		return
+	}
+	samples, err := database.GetProgressSamples(s.RDB, s.ctx, progressWindow)
+	if err != nil {
+		slog.Warn("failed to get progress", "error", err)
+		return
+	}
+	p, ok := database.EstimateProgress(samples)
+	if !ok {
+		return
+	}
+	slog.Info("crawl progress",
+		"pending", sample.Pending,
+		"done", sample.Done,
+		"failed", sample.Failed,
+		"jobs_per_min", int(p.JobsPerMinute),
+		"tracks_per_min", int(p.TracksPerMinute),
+		"frontier_growth_per_min", int(p.FrontierGrowth),
+		"eta", p.ETA.Round(time.Minute),
+	)
+}
@@ -0,0 +1,59 @@
+package scraper
+
+import (
+	"log/slog"
+
+	"github.com/Pineapple217/MetaRaid/pkg/config"
+	spt "github.com/Pineapple217/MetaRaid/pkg/spotify"
+)
+
+// UpdateClients applies a reloaded Spotify config. New keys are probed and
+// get workers, removed keys are retired and unchanged keys only get the new
+// MaxRetryDuration, so their workers keep running. A config without keys is
+// ignored, it is more likely a half written file than a wish to stop.
+func (s *Scraper) UpdateClients(conf config.Spotify) {
+	if len(conf.Clients) == 0 {
+		slog.Warn("Reloaded config has no keys, keeping the current keys")
+		return
+	}
+	current := make(map[string]*spt.Client)
+	known := make(map[*spt.Client]struct{})
+	for _, c := range s.clientList() {
+		current[c.ClientId()] = c
+		known[c] = struct{}{}
+	}
+
+	clients := []*spt.Client{}
+	for _, key := range conf.Clients {
+		if c, ok := current[key.ClientId]; ok {
+			c.SetMaxRetryDuration(conf.MaxRetryDuration)
+			clients = append(clients, c)
+			delete(current, key.ClientId)
+			continue
+		}
+		c, err := spt.NewKeyClient(s.ctx, conf, key)
+		if err != nil {
+			slog.Warn("Failed to add key", "name", key.Name, "error", err)
+			continue
+		}
+		slog.Info("Added key", "name", c.Name, "status", c.Status.String())
+		clients = append(clients, c)
+	}
+	if len(clients) == 0 {
+		slog.Warn("None of the reloaded keys could be added, keeping the current keys")
+		return
+	}
+	for _, c := range current {
+		slog.Info("Retired key", "name", c.Name)
+	}
+
+	s.mu.Lock()
+	s.Clients = clients
+	s.mu.Unlock()
+	for _, c := range clients {
+		if _, ok := known[c]; !ok {
+			s.startAnalysis(c)
+		}
+	}
+	s.balance()
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
 	"os"
 	"sync"
 	"sync/atomic"
@@ -14,20 +15,48 @@ import (
 	"github.com/Pineapple217/MetaRaid/pkg/config"
 	"github.com/Pineapple217/MetaRaid/pkg/database"
 	"github.com/Pineapple217/MetaRaid/pkg/helper"
+	"github.com/Pineapple217/MetaRaid/pkg/notify"
 	spt "github.com/Pineapple217/MetaRaid/pkg/spotify"
 	"github.com/redis/go-redis/v9"
 	"github.com/zmb3/spotify/v2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = otel.Tracer("github.com/Pineapple217/MetaRaid/pkg/scraper")
+
 type Scraper struct {
 	Clients []*spt.Client
 	RDB     *redis.Client
 	Config  config.Scraper
 	Wg      sync.WaitGroup
 	Jobs    chan string
-	workers []*Worker
 	ctx     context.Context
 	cancel  context.CancelFunc
+	pause   *gate
+
+	mu       sync.RWMutex
+	workers  []*Worker
+	poolSize int
+	nextId   int
+	index    *database.Index
+
+	statsMu      sync.Mutex
+	requestStats map[string]map[string]spt.EndpointStats
+
+	subMu       sync.Mutex
+	subscribers map[int]func(Transition)
+	nextSub     int
+	statusCh    chan Transition
+
+	analysisOnce sync.Once
+	analysisIds  chan string
+
+	notifier *notify.Notifier
+	webhook  config.Webhook
+	counts   *jobCounts
 }
 
 type Worker struct {
@@ -35,84 +64,147 @@ type Worker struct {
 	id           string
 	logger       *slog.Logger
 	rdb          *redis.Client
+	index        *database.Index
 	ctx          context.Context
 	cancel       context.CancelFunc
 	requestCount int64
 	trackCount   int64
-	status       status
-}
-
-type status int
+	pause        *gate
+	busy         atomic.Bool
+	notify       func(Transition)
+	notifier     *notify.Notifier
+	counts       *jobCounts
 
-const (
-	initialized status = iota
-	running
-	coldKey
-	stopped
-)
+	// mu guards the fields below
+	mu            sync.Mutex
+	cancelJob     context.CancelFunc
+	job           string
+	jobStart      time.Time
+	stallReported bool
+	status        status
+	statusTimes   map[status]time.Time
+}
 
-func NewScraper(clients []*spt.Client, rdb *redis.Client, conf config.Scraper) *Scraper {
+func NewScraper(clients []*spt.Client, rdb *redis.Client, conf config.Scraper, webhook config.Webhook) *Scraper {
 	ctx, cancel := context.WithCancel(context.Background())
-	ws := []*Worker{}
 
-	for i, c := range clients {
+	for _, c := range clients {
 		if c.Status == spt.Cold {
 			slog.Warn("Client is not ready for use", "name", c.Name, "status", c.Status.String(), "cooldown", c.Cooldown)
-			continue
 		}
-		name := fmt.Sprintf("%d", i)
-		logger := slog.With(slog.Group("worker"), slog.String("id", name))
-		workerCtx, workerCancel := context.WithCancel(ctx)
-		ws = append(ws, &Worker{
-			client: c,
-			id:     name,
-			logger: logger,
-			rdb:    rdb,
-			ctx:    workerCtx,
-			cancel: workerCancel,
-			status: initialized,
-		})
+	}
+	poolSize := conf.WorkerCount
+	if poolSize <= 0 {
+		poolSize = len(clients)
 	}
 
 	s := Scraper{
-		Clients: clients,
-		RDB:     rdb,
-		Config:  conf,
-		Wg:      sync.WaitGroup{},
-		Jobs:    make(chan string, 20),
-		workers: ws,
-		ctx:     ctx,
-		cancel:  cancel,
+		Clients:  clients,
+		RDB:      rdb,
+		Config:   conf,
+		Wg:       sync.WaitGroup{},
+		Jobs:     make(chan string, 20),
+		workers:  []*Worker{},
+		poolSize: poolSize,
+		index:    database.NewIndex(rdb),
+		ctx:      ctx,
+		cancel:   cancel,
+		pause:    newGate(false),
+
+		requestStats: make(map[string]map[string]spt.EndpointStats),
+		subscribers:  make(map[int]func(Transition)),
+		statusCh:     make(chan Transition, 256),
+		analysisIds:  make(chan string),
+		notifier:     notify.New(webhook),
+		webhook:      webhook,
+		counts:       &jobCounts{},
 	}
 	return &s
 }
 
 func (s *Scraper) Start() {
-	if len(s.workers) == 0 {
-		slog.Error("Can not start scraper with 0 workers")
+	if len(s.Clients) == 0 {
+		slog.Error("Can not start scraper without keys")
 		go syscall.Kill(os.Getpid(), syscall.SIGINT)
 		return
 	}
-	slog.Info("Starting scraper")
+	slog.Info("Starting scraper", "workers", s.PoolSize())
 	ctx := context.Background()
-	err := database.RecoverInProgressTasks(s.RDB, ctx)
+	repair, err := database.NeedsRepair(s.RDB, ctx)
+	helper.MaybeDieErr(err)
+	if repair {
+		slog.Error("Found jobs stored by an older version, run `jobs repair` first")
+		go syscall.Kill(os.Getpid(), syscall.SIGINT)
+		return
+	}
+	err = database.RecoverInProgressTasks(s.RDB, ctx)
+	helper.MaybeDieErr(err)
+	err = database.RecoverAnalysis(s.RDB, ctx)
 	helper.MaybeDieErr(err)
 	err = database.EnsureSeedJob(s.RDB, ctx, s.Config.SeedArtistId)
 	helper.MaybeDieErr(err)
+	s.counts.last.Store(time.Now().UnixNano())
+	s.Subscribe(s.publishTransition)
+	s.Subscribe(countRetired)
+	s.Wg.Add(4)
 	go s.fetchJobs()
-	go s.runWorkers()
 	go s.workerManage()
+	go s.collectRequestStats()
+	go s.dispatchTransitions()
+	for _, c := range s.clientList() {
+		s.startAnalysis(c)
+	}
+	s.balance()
 }
 
+// Stop cancels all workers, which requeue the job they were working on,
+// returns the jobs that were fetched but not started to the pending queue and
+// waits for notifications that are still being sent.
 func (s *Scraper) Stop() {
 	slog.Info("Stopping scraper")
 	s.cancel()
-	s.Wg.Wait()
+
+	done := make(chan struct{})
+	go func() {
+		s.Wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(s.Config.ShutdownTimeout):
+		slog.Warn("Timed out waiting for workers to stop", "timeout", s.Config.ShutdownTimeout)
+	}
+
+	s.returnBufferedJobs()
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.ShutdownTimeout)
+	defer cancel()
+	s.notifier.Close(ctx)
+}
+
+func (s *Scraper) returnBufferedJobs() {
+	jobs := []string{}
+loop:
+	for {
+		select {
+		case job := <-s.Jobs:
+			jobs = append(jobs, job)
+		default:
+			break loop
+		}
+	}
+	err := database.RequeueJobs(s.RDB, context.Background(), jobs)
+	if err != nil {
+		slog.Error("Failed to return buffered jobs", "count", len(jobs), "error", err)
+		return
+	}
+	slog.Info("Returned buffered jobs to the queue", "count", len(jobs))
 }
 
 func (w *Worker) Start(wg *sync.WaitGroup, jobs chan string) {
-	if w.status != initialized {
-		slog.Warn("Can not start worker, incorrect status", "status", w.status)
+	if !w.setStatus(running) {
+		slog.Warn("Can not start worker, incorrect status", "status", w.getStatus())
+		wg.Done()
 		return
 	}
 	w.logger.Info("Starting")
@@ -132,121 +224,323 @@ func (w *Worker) Start(wg *sync.WaitGroup, jobs chan string) {
 	}()
 	go func() {
 		defer wg.Done()
-		ctx := context.Background()
 		for {
+			paused, changed := w.pause.state()
+			if paused {
+				select {
+				case <-w.ctx.Done():
+					w.logger.Info("stopped worker")
+					return
+				case <-changed:
+				}
+				continue
+			}
 			select {
 			case <-w.ctx.Done():
 				w.logger.Info("stopped worker")
 				return
-			default:
-				if len(jobs) == 0 {
-					time.Sleep(time.Second)
-					continue
-				}
-				job := <-jobs
-				w.logger.Info("working", "job", job)
-				fs, c, err := w.client.FetchArtistTracks(ctx, spotify.ID(job))
-				var maxErr *spotify.MaxRetryDurationExceededErr
-				if errors.As(err, &maxErr) {
-					w.logger.Warn("Max retry duration exceeded, cold key")
-					w.status = coldKey
-					w.client.UpdateStatus(maxErr)
-					jobs <- job
-					w.Stop()
+			case <-changed:
+			case job := <-jobs:
+				if !w.process(job, jobs) {
 					return
 				}
-				helper.MaybeDie(err, "Failed to ferch artists tracks")
-				w.logger.Info("tracks fetched", "artist", job, "count", len(fs), "request_count", c)
-
-				err = database.InsertTracks(w.rdb, ctx, fs)
-				helper.MaybeDie(err, "Failed to add tracks")
+			}
+		}
+	}()
+}
 
-				as := spt.GetArtists(fs, spotify.ID(job))
-				err = database.AddJobs(w.rdb, ctx, as)
-				helper.MaybeDie(err, "Failed to add tasks")
+// process runs a single job, it returns false when the worker has to stop.
+func (w *Worker) process(job string, jobs chan string) bool {
+	w.busy.Store(true)
+	defer w.busy.Store(false)
 
-				atomic.AddInt64(&w.requestCount, int64(c))
-				atomic.AddInt64(&w.trackCount, int64(len(fs)))
+	// The fetch is cancelled by Stop or an aborting Pause. Redis calls use
+	// their own context so the outcome of a job is always stored, it only
+	// carries the span of the job.
+	jobCtx, cancel := context.WithCancel(w.ctx)
+	jobCtx, span := tracer.Start(jobCtx, "job", trace.WithAttributes(
+		attribute.String("job.artist", job),
+		attribute.String("job.key", w.client.Name),
+		attribute.String("job.worker", w.id),
+	))
+	defer span.End()
+	start := time.Now()
+	w.mu.Lock()
+	w.cancelJob = cancel
+	w.job = job
+	w.jobStart = start
+	w.stallReported = false
+	w.mu.Unlock()
+	defer func() {
+		w.mu.Lock()
+		w.cancelJob = nil
+		w.job = ""
+		w.mu.Unlock()
+		cancel()
+	}()
+	ctx := trace.ContextWithSpan(context.Background(), span)
 
-				err = database.MarkJobDone(w.rdb, ctx, job)
-				if err != nil {
-					w.logger.Error("Failed to mark job as done", "job", job)
-				}
+	w.logger.Info("working", "job", job)
+	err := database.PublishEvent(w.rdb, ctx, database.Event{Type: database.JobStarted, Job: job, Key: w.client.Name})
+	if err != nil {
+		w.logger.Warn("Failed to publish event", "error", err)
+	}
+	// every batch is stored as soon as it is fetched, a failed or aborted job
+	// keeps its progress and only fetches the remaining tracks when retried
+	trackCount := 0
+	c, err := w.client.StreamArtistTracks(jobCtx, spotify.ID(job), w.index, func(batch []*spt.FullerTrack) error {
+		err := database.InsertTracks(w.rdb, ctx, job, batch)
+		if err != nil {
+			return fmt.Errorf("failed to add tracks: %w", err)
+		}
+		as := spt.GetArtists(batch, spotify.ID(job))
+		err = database.AddJobs(w.rdb, ctx, as)
+		if err != nil {
+			return fmt.Errorf("failed to add tasks: %w", err)
+		}
+		err = database.AddJobTracks(w.rdb, ctx, job, len(batch))
+		if err != nil {
+			return fmt.Errorf("failed to update job: %w", err)
+		}
+		if w.client.AnalysisEnabled() {
+			ids := make([]string, len(batch))
+			for i, t := range batch {
+				ids[i] = t.Track.ID.String()
+			}
+			err = database.QueueAnalysis(w.rdb, ctx, ids)
+			if err != nil {
+				return fmt.Errorf("failed to queue audio analysis: %w", err)
 			}
 		}
-	}()
-	w.status = running
+		trackCount += len(batch)
+		tracksInserted.Add(float64(len(batch)))
+		atomic.AddInt64(&w.trackCount, int64(len(batch)))
+		return nil
+	})
+	atomic.AddInt64(&w.requestCount, int64(c))
+	span.SetAttributes(attribute.Int("job.tracks", trackCount), attribute.Int("job.requests", c))
+	var maxErr *spotify.MaxRetryDurationExceededErr
+	if errors.As(err, &maxErr) {
+		w.logger.Warn("Max retry duration exceeded, cold key")
+		span.SetAttributes(attribute.String("job.outcome", "cold_key"))
+		w.setStatus(coldKey)
+		w.client.UpdateStatus(maxErr)
+		err = database.PublishEvent(w.rdb, ctx, database.Event{Type: database.KeyCold, Key: w.client.Name})
+		if err != nil {
+			w.logger.Warn("Failed to publish event", "error", err)
+		}
+		cooldown := w.client.CooldownRemaining()
+		w.notifier.Notify(notify.Notification{
+			Event:   notify.KeyCold,
+			Message: fmt.Sprintf("Key %s is cold for %s", w.client.Name, cooldown),
+			Key:     w.client.Name,
+			Details: map[string]any{"cooldown_seconds": cooldown.Seconds()},
+		})
+		// hand the job to another worker, or back to redis when the buffer is
+		// full so this never blocks
+		select {
+		case jobs <- job:
+		default:
+			err = database.RequeueJob(w.rdb, ctx, job)
+			if err != nil {
+				w.logger.Error("Failed to requeue job", "job", job, "error", err)
+			}
+		}
+		w.Stop()
+		return false
+	}
+	if err != nil && jobCtx.Err() != nil {
+		w.logger.Info("job aborted, requeueing", "job", job, "stored", trackCount)
+		span.SetAttributes(attribute.String("job.outcome", "aborted"))
+		err = database.RequeueJob(w.rdb, ctx, job)
+		if err != nil {
+			w.logger.Error("Failed to requeue job", "job", job, "error", err)
+		}
+		return true
+	}
+	if err != nil {
+		span.SetAttributes(attribute.String("job.outcome", "failed"))
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		w.counts.finished(true)
+		err = database.MarkJobFailed(w.rdb, ctx, job, err)
+		if err != nil {
+			w.logger.Error("Failed to mark job as failed", "job", job, "error", err)
+		}
+		return true
+	}
+	w.logger.Info("tracks fetched", "artist", job, "count", trackCount, "request_count", c)
+
+	span.SetAttributes(attribute.String("job.outcome", "done"))
+	w.counts.finished(false)
+	err = database.MarkJobDone(w.rdb, ctx, job)
+	if err != nil {
+		w.logger.Error("Failed to mark job as done", "job", job)
+	}
+	jobDuration.WithLabelValues(w.client.Name).Observe(time.Since(start).Seconds())
+	return true
 }
 
 func (w *Worker) Stop() {
 	w.logger.Info("stopping")
 	w.cancel()
-	w.status = stopped
-}
-
-func (s *Scraper) runWorkers() {
-	s.Wg.Add(len(s.workers))
-	for _, w := range s.workers {
-		w.Start(&s.Wg, s.Jobs)
-	}
-	s.Wg.Wait()
+	w.setStatus(stopped)
 }
 
 func (s *Scraper) workerManage() {
-	s.Wg.Add(1)
 	defer s.Wg.Done()
 	ticker := time.NewTicker(5 * time.Second)
 	defer ticker.Stop()
+	minuteTicker := time.NewTicker(time.Minute)
+	defer minuteTicker.Stop()
+	var in incidents
+	waiting := false
 	for {
 		select {
 		case <-s.ctx.Done():
 			slog.Info("stopped workerManager")
 			return
+		case <-minuteTicker.C:
+			s.checkErrorRate()
+			s.trackProgress()
 		case <-ticker.C:
-			stp := 0
+			s.warmUp()
+			s.balance()
 			r := 0
-			for _, w := range s.workers {
-				switch w.status {
-				case stopped:
-					stp++
-				case running:
+			for _, w := range s.workerList() {
+				if w.getStatus() == running {
 					r++
 				}
 			}
-			slog.Info("worker pool state", "stopped", stp, "running", r)
-			if r == 0 {
+			cold := 0
+			for _, c := range s.clientList() {
+				if !c.IsAvailable() {
+					cold++
+				}
+			}
+			slog.Info("worker pool state", "running", r, "size", s.PoolSize(), "cold_keys", cold)
+			s.checkQueue(&in)
+			s.checkStalls()
+			if r > 0 {
+				waiting = false
+				continue
+			}
+			// keys recover through warmUp, only give up when none of
+			// them will within MaxKeyCooldown
+			recovery := time.Duration(-1)
+			for _, c := range s.clientList() {
+				if d := c.CooldownRemaining(); recovery < 0 || d < recovery {
+					recovery = d
+				}
+			}
+			if s.Config.MaxKeyCooldown > 0 && recovery > s.Config.MaxKeyCooldown {
+				s.notifier.Notify(notify.Notification{
+					Event:   notify.AllWorkersStopped,
+					Message: fmt.Sprintf("All workers stopped, %d of %d keys are cold for at least %s, shutting down", cold, len(s.clientList()), recovery.Round(time.Second)),
+				})
 				go syscall.Kill(os.Getpid(), syscall.SIGINT)
+			} else if !waiting {
+				waiting = true
+				slog.Warn("All workers stopped, waiting for keys to recover", "cold_keys", cold, "recovery_in", max(recovery, 0))
+				s.notifier.Notify(notify.Notification{
+					Event:   notify.AllWorkersStopped,
+					Message: fmt.Sprintf("All workers stopped, %d of %d keys are cold, waiting for them to recover", cold, len(s.clientList())),
+				})
 			}
 		}
 	}
 }
 
-func (s *Scraper) fetchJobs() {
-	s.Wg.Add(1)
+// RequestStats returns the request counters per key and endpoint of the
+// last minute.
+func (s *Scraper) RequestStats() map[string]map[string]spt.EndpointStats {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	return maps.Clone(s.requestStats)
+}
+
+func (s *Scraper) collectRequestStats() {
 	defer s.Wg.Done()
-	ctx := context.Background()
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
 	for {
 		select {
 		case <-s.ctx.Done():
-			slog.Info("stopped job fetcher")
+			slog.Info("stopped request stats collector")
 			return
-		default:
-			if len(s.Jobs) > 10 {
-				time.Sleep(time.Second)
-				continue
+		case t := <-ticker.C:
+			for _, c := range s.clientList() {
+				stats := c.Stats.Swap()
+				s.statsMu.Lock()
+				s.requestStats[c.Name] = stats
+				s.statsMu.Unlock()
+
+				for name, e := range stats {
+					requestsTotal.WithLabelValues(c.Name, name).Add(float64(e.Requests))
+					slog.Debug("Requests per minute",
+						"client", c.Name,
+						"endpoint", name,
+						"count", e.Requests,
+						"avg_latency", e.Latency/time.Duration(e.Requests),
+						"retry_wait", e.RetryWait,
+					)
+				}
+				err := database.SaveRequestStats(s.RDB, s.ctx, c.Name, t, stats)
+				if err != nil {
+					slog.Warn("failed to save request stats", "client", c.Name, "error", err)
+				}
 			}
-			tasks, err := database.PopJobs(s.RDB, ctx, 5)
-			if err != nil {
-				slog.Warn("failed to fetch tasks", "error", err)
+		}
+	}
+}
+
+// fetchJobs moves pending jobs into Jobs. It blocks on sending while Jobs is
+// full and on jobs_signal while the pending queue is empty.
+func (s *Scraper) fetchJobs() {
+	defer s.Wg.Done()
+	defer slog.Info("stopped job fetcher")
+	ctx := context.Background()
+	for {
+		paused, changed := s.pause.state()
+		if paused {
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-changed:
+			}
+			continue
+		}
+		tasks, err := database.PopJobs(s.RDB, ctx, 5)
+		if err != nil {
+			slog.Warn("failed to fetch tasks", "error", err)
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-time.After(3 * time.Second):
+			}
+			continue
+		}
+		if len(tasks) == 0 {
+			slog.Debug("no jobs to fetch, waiting for new jobs")
+			err = database.WaitForJobs(s.RDB, s.ctx, 5*time.Second)
+			if err != nil && s.ctx.Err() == nil {
+				slog.Warn("failed to wait for jobs", "error", err)
 			}
-			if len(tasks) == 0 {
-				slog.Info("no jobs to fetch, waiting", "sec", 3)
-				time.Sleep(time.Second * 3)
-			} else {
-				slog.Info("adding tracks to task queue", "count", len(tasks))
+			if s.ctx.Err() != nil {
+				return
 			}
-			for _, task := range tasks {
-				s.Jobs <- task
+			continue
+		}
+		slog.Info("adding tracks to task queue", "count", len(tasks))
+		for i, task := range tasks {
+			select {
+			case s.Jobs <- task:
+			case <-s.ctx.Done():
+				err = database.RequeueJobs(s.RDB, ctx, tasks[i:])
+				if err != nil {
+					slog.Error("Failed to return fetched jobs", "error", err)
+				}
+				return
 			}
 		}
 	}
@@ -0,0 +1,156 @@
+package scraper
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/Pineapple217/MetaRaid/pkg/database"
+)
+
+type status int
+
+const (
+	initialized status = iota
+	running
+	coldKey
+	stopped
+)
+
+func (s status) String() string {
+	switch s {
+	case initialized:
+		return "initialized"
+	case running:
+		return "running"
+	case coldKey:
+		return "coldKey"
+	case stopped:
+		return "stopped"
+	default:
+		return "unknown"
+	}
+}
+
+// transitions lists the states a worker can move to from each state,
+// stopped is final.
+var transitions = map[status][]status{
+	initialized: {running, stopped},
+	running:     {coldKey, stopped},
+	coldKey:     {stopped},
+}
+
+type Transition struct {
+	Worker string    `json:"worker"`
+	Key    string    `json:"key"`
+	From   string    `json:"from"`
+	To     string    `json:"to"`
+	At     time.Time `json:"at"`
+}
+
+// setStatus moves the worker to status to and notifies the subscribers. It
+// returns false and changes nothing when the transition is not allowed.
+func (w *Worker) setStatus(to status) bool {
+	w.mu.Lock()
+	from := w.status
+	if !slices.Contains(transitions[from], to) {
+		w.mu.Unlock()
+		return false
+	}
+	now := time.Now()
+	w.status = to
+	w.statusTimes[to] = now
+	w.mu.Unlock()
+
+	w.logger.Debug("status changed", "from", from, "to", to)
+	if w.notify != nil {
+		w.notify(Transition{
+			Worker: w.id,
+			Key:    w.client.Name,
+			From:   from.String(),
+			To:     to.String(),
+			At:     now,
+		})
+	}
+	return true
+}
+
+func (w *Worker) getStatus() status {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.status
+}
+
+// Subscribe calls fn for every status change of every worker until the
+// returned function is called. fn runs on a single dispatch goroutine that
+// holds no locks, so it may call back into the scraper but a slow fn delays
+// the others.
+func (s *Scraper) Subscribe(fn func(Transition)) func() {
+	s.subMu.Lock()
+	defer s.subMu.Unlock()
+	id := s.nextSub
+	s.nextSub++
+	s.subscribers[id] = fn
+	return func() {
+		s.subMu.Lock()
+		defer s.subMu.Unlock()
+		delete(s.subscribers, id)
+	}
+}
+
+// notify queues t for the subscribers. Workers change status while balance
+// holds s.mu, so it never blocks and drops t when the queue is full.
+func (s *Scraper) notify(t Transition) {
+	select {
+	case s.statusCh <- t:
+	default:
+		slog.Warn("Status change queue is full, dropping transition", "worker", t.Worker, "to", t.To)
+	}
+}
+
+// dispatchTransitions delivers queued transitions until the scraper stops,
+// then delivers what is still queued.
+func (s *Scraper) dispatchTransitions() {
+	defer s.Wg.Done()
+	for {
+		select {
+		case t := <-s.statusCh:
+			s.deliver(t)
+		case <-s.ctx.Done():
+			for {
+				select {
+				case t := <-s.statusCh:
+					s.deliver(t)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (s *Scraper) deliver(t Transition) {
+	s.subMu.Lock()
+	fns := make([]func(Transition), 0, len(s.subscribers))
+	for _, fn := range s.subscribers {
+		fns = append(fns, fn)
+	}
+	s.subMu.Unlock()
+	for _, fn := range fns {
+		fn(t)
+	}
+}
+
+// publishTransition adds t to the events stream.
+func (s *Scraper) publishTransition(t Transition) {
+	err := database.PublishEvent(s.RDB, context.Background(), database.Event{
+		Type:   database.WorkerStatus,
+		Key:    t.Key,
+		Worker: t.Worker,
+		Status: t.To,
+	})
+	if err != nil {
+		slog.Warn("Failed to publish event", "error", err)
+	}
+}
@@ -0,0 +1,65 @@
+package scraper
+
+import (
+	"log/slog"
+	"testing"
+	"time"
+
+	spt "github.com/Pineapple217/MetaRaid/pkg/spotify"
+)
+
+func TestTransitions(t *testing.T) {
+	tests := []struct {
+		from, to status
+		allowed  bool
+	}{
+		{initialized, running, true},
+		{initialized, stopped, true},
+		{initialized, coldKey, false},
+		{initialized, initialized, false},
+		{running, coldKey, true},
+		{running, stopped, true},
+		{running, initialized, false},
+		{running, running, false},
+		{coldKey, stopped, true},
+		{coldKey, running, false},
+		{coldKey, initialized, false},
+		{stopped, initialized, false},
+		{stopped, running, false},
+		{stopped, coldKey, false},
+		{stopped, stopped, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.from.String()+"->"+tt.to.String(), func(t *testing.T) {
+			var got []Transition
+			w := &Worker{
+				client:      &spt.Client{Name: "key"},
+				id:          "1",
+				logger:      slog.Default(),
+				notify:      func(t Transition) { got = append(got, t) },
+				status:      tt.from,
+				statusTimes: map[status]time.Time{},
+			}
+			ok := w.setStatus(tt.to)
+			if ok != tt.allowed {
+				t.Fatalf("setStatus = %v, want %v", ok, tt.allowed)
+			}
+			want := tt.from
+			if tt.allowed {
+				want = tt.to
+			}
+			if s := w.getStatus(); s != want {
+				t.Errorf("status = %v, want %v", s, want)
+			}
+			if !tt.allowed {
+				if len(got) != 0 {
+					t.Errorf("notified %d times for a rejected transition", len(got))
+				}
+				return
+			}
+			if len(got) != 1 || got[0].From != tt.from.String() || got[0].To != tt.to.String() {
+				t.Errorf("notified %+v, want one %v -> %v", got, tt.from, tt.to)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package scraper
+
+import (
+	"time"
+)
+
+// currentJob returns the job the worker is working on and when it started,
+// job is empty when the worker is idle.
+func (w *Worker) currentJob() (job string, start time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.job, w.jobStart
+}
+
+// stalled reports whether the current job has been running longer than
+// timeout.
+func (w *Worker) stalled(timeout time.Duration) bool {
+	job, start := w.currentJob()
+	return timeout > 0 && job != "" && time.Since(start) > timeout
+}
+
+// abortJob cancels the current job, the worker returns it to the pending
+// queue and continues with the next one. It returns false when the worker
+// is idle.
+func (w *Worker) abortJob() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.cancelJob == nil {
+		return false
+	}
+	w.cancelJob()
+	return true
+}
+
+// AbortJob cancels and requeues the current job of a worker.
+func (s *Scraper) AbortJob(id string) error {
+	w, err := s.worker(id)
+	if err != nil {
+		return err
+	}
+	if !w.abortJob() {
+		return ErrWorkerIdle
+	}
+	return nil
+}
+
+// checkStalls logs every job that runs longer than the stall timeout once,
+// and cancels and requeues it when configured to.
+func (s *Scraper) checkStalls() {
+	timeout := s.Config.StallTimeout
+	for _, w := range s.workerList() {
+		if !w.stalled(timeout) {
+			continue
+		}
+		w.mu.Lock()
+		job, start, reported := w.job, w.jobStart, w.stallReported
+		w.stallReported = true
+		w.mu.Unlock()
+		if reported {
+			continue
+		}
+
+		w.logger.Warn("Job stalled", "job", job, "running", time.Since(start).Round(time.Second), "abort", s.Config.AbortStalled)
+		if s.Config.AbortStalled {
+			w.abortJob()
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/Pineapple217/MetaRaid/pkg/database"
+	"github.com/Pineapple217/MetaRaid/pkg/scraper"
+	"github.com/zmb3/spotify/v2"
+)
+
+type admin struct {
+	s     *scraper.Scraper
+	token string
+}
+
+func registerAdmin(mux *http.ServeMux, s *scraper.Scraper, token string) {
+	a := admin{s: s, token: token}
+	mux.HandleFunc("GET /admin/workers", a.auth(a.listWorkers))
+	mux.HandleFunc("PUT /admin/workers/count", a.auth(a.scale))
+	mux.HandleFunc("DELETE /admin/workers/{id}", a.auth(a.removeWorker))
+	mux.HandleFunc("POST /admin/workers/{id}/pause", a.auth(a.pauseWorker))
+	mux.HandleFunc("POST /admin/workers/{id}/resume", a.auth(a.resumeWorker))
+	mux.HandleFunc("POST /admin/workers/{id}/abort", a.auth(a.abortJob))
+	mux.HandleFunc("POST /admin/pause", a.auth(a.pause))
+	mux.HandleFunc("POST /admin/resume", a.auth(a.resume))
+	mux.HandleFunc("POST /admin/drain", a.auth(a.drain))
+	mux.HandleFunc("POST /admin/jobs", a.auth(a.addJobs))
+	mux.HandleFunc("POST /admin/jobs/requeue", a.auth(a.requeueFailed))
+	mux.HandleFunc("PUT /admin/log-level", a.auth(a.setLogLevel))
+}
+
+func (a *admin) auth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if a.token != "" {
+			got := []byte(r.Header.Get("Authorization"))
+			want := []byte("Bearer " + a.token)
+			if subtle.ConstantTimeCompare(got, want) != 1 {
+				writeError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		slog.Warn("failed to write response", "error", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// abort reports whether the current jobs should be cancelled, ?abort=true.
+func abort(r *http.Request) bool {
+	return r.URL.Query().Get("abort") == "true"
+}
+
+func (a *admin) listWorkers(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.s.Workers())
+}
+
+func (a *admin) scale(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Count int `json:"count"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if body.Count < 1 {
+		writeError(w, http.StatusBadRequest, errors.New("count must be at least 1"))
+		return
+	}
+	a.s.Scale(body.Count)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *admin) removeWorker(w http.ResponseWriter, r *http.Request) {
+	err := a.s.RemoveWorker(r.PathValue("id"))
+	if errors.Is(err, scraper.ErrLastWorker) {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *admin) pauseWorker(w http.ResponseWriter, r *http.Request) {
+	err := a.s.PauseWorker(r.PathValue("id"), abort(r))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *admin) resumeWorker(w http.ResponseWriter, r *http.Request) {
+	err := a.s.ResumeWorker(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *admin) abortJob(w http.ResponseWriter, r *http.Request) {
+	err := a.s.AbortJob(r.PathValue("id"))
+	if errors.Is(err, scraper.ErrWorkerIdle) {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *admin) pause(w http.ResponseWriter, r *http.Request) {
+	a.s.Pause(abort(r))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *admin) resume(w http.ResponseWriter, r *http.Request) {
+	a.s.Resume()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *admin) drain(w http.ResponseWriter, r *http.Request) {
+	a.s.Drain()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (a *admin) addJobs(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Ids []spotify.ID `json:"ids"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if len(body.Ids) == 0 {
+		writeError(w, http.StatusBadRequest, errors.New("no ids given"))
+		return
+	}
+	err = database.AddJobs(a.s.RDB, r.Context(), body.Ids)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *admin) requeueFailed(w http.ResponseWriter, r *http.Request) {
+	n, err := database.RequeueFailedJobs(a.s.RDB, r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int64{"requeued": n})
+}
+
+func (a *admin) setLogLevel(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Level string `json:"level"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	var level slog.Level
+	err = level.UnmarshalText([]byte(body.Level))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	slog.SetLogLoggerLevel(level)
+	slog.Info("Changed log level", "level", level)
+	w.WriteHeader(http.StatusNoContent)
+}
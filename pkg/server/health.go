@@ -0,0 +1,34 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/Pineapple217/MetaRaid/pkg/scraper"
+)
+
+type health struct {
+	s *scraper.Scraper
+}
+
+// registerHealth adds the liveness and readiness probes, they answer 503
+// when the check fails and always include the state they were based on.
+func registerHealth(mux *http.ServeMux, s *scraper.Scraper) {
+	h := health{s: s}
+	mux.HandleFunc("GET /healthz", h.check(s.Alive))
+	mux.HandleFunc("GET /readyz", h.check(s.Ready))
+}
+
+func (h *health) check(ok func(scraper.Health) bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+		defer cancel()
+		state := h.s.Health(ctx)
+		status := http.StatusOK
+		if !ok(state) {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, status, state)
+	}
+}
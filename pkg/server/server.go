@@ -0,0 +1,58 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/Pineapple217/MetaRaid/pkg/config"
+	"github.com/Pineapple217/MetaRaid/pkg/scraper"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Server is the optional HTTP listener of the scraper.
+type Server struct {
+	srv *http.Server
+}
+
+func New(conf config.Server, s *scraper.Scraper) *Server {
+	mux := http.NewServeMux()
+	registerHealth(mux, s)
+	if conf.Metrics {
+		prometheus.MustRegister(s)
+		mux.Handle("GET /metrics", promhttp.Handler())
+	}
+	if conf.Admin {
+		registerAdmin(mux, s, conf.AdminToken)
+	}
+
+	return &Server{
+		srv: &http.Server{
+			Addr:              conf.Addr,
+			Handler:           mux,
+			ReadHeaderTimeout: 10 * time.Second,
+		},
+	}
+}
+
+func (s *Server) Start() {
+	slog.Info("Starting HTTP server", "addr", s.srv.Addr)
+	go func() {
+		err := s.srv.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("HTTP server failed", "error", err)
+		}
+	}()
+}
+
+func (s *Server) Stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := s.srv.Shutdown(ctx)
+	if err != nil {
+		slog.Warn("failed to stop HTTP server", "error", err)
+	}
+}
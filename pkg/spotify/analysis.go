@@ -0,0 +1,64 @@
+package spotify
+
+import (
+	"context"
+	"errors"
+
+	"github.com/zmb3/spotify/v2"
+)
+
+// AnalysisSummary is a compact summary of a track's audio analysis, the full
+// analysis is far too large to store for every track.
+type AnalysisSummary struct {
+	Sections        int
+	Segments        int
+	Beats           int
+	Bars            int
+	Tatums          int
+	TempoConfidence float64
+	KeyConfidence   float64
+	KeyChanges      int
+}
+
+func NewAnalysisSummary(a *spotify.AudioAnalysis) *AnalysisSummary {
+	keyChanges := 0
+	for i := 1; i < len(a.Sections); i++ {
+		if a.Sections[i].Key != a.Sections[i-1].Key {
+			keyChanges++
+		}
+	}
+	return &AnalysisSummary{
+		Sections:        len(a.Sections),
+		Segments:        len(a.Segments),
+		Beats:           len(a.Beats),
+		Bars:            len(a.Bars),
+		Tatums:          len(a.Tatums),
+		TempoConfidence: a.Track.TempoConfidence,
+		KeyConfidence:   a.Track.KeyConfidence,
+		KeyChanges:      keyChanges,
+	}
+}
+
+// AnalysisEnabled reports whether the key has an audio analysis budget.
+func (c *Client) AnalysisEnabled() bool {
+	return c.analysisLimiter != nil
+}
+
+// FetchAnalysis waits until the analysis budget of the key allows another
+// request and fetches the audio analysis summary of a track.
+func (c *Client) FetchAnalysis(ctx context.Context, id spotify.ID) (*AnalysisSummary, error) {
+	if c.analysisLimiter == nil {
+		return nil, errors.New("audio analysis is disabled")
+	}
+	err := c.analysisLimiter.Wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cctx, cl := startCall(ctx, "GetAudioAnalysis", 1)
+	a, err := c.api().GetAudioAnalysis(cctx, id)
+	cl.end(err)
+	if err != nil {
+		return nil, err
+	}
+	return NewAnalysisSummary(a), nil
+}
@@ -3,8 +3,12 @@ package spotify
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log/slog"
+	"maps"
+	"net/http"
 	"slices"
+	"sync"
 	"time"
 
 	"github.com/Pineapple217/MetaRaid/pkg/config"
@@ -12,13 +16,26 @@ import (
 	"github.com/zmb3/spotify/v2"
 	spotifyauth "github.com/zmb3/spotify/v2/auth"
 	"golang.org/x/oauth2/clientcredentials"
+	"golang.org/x/time/rate"
 )
 
 type Client struct {
-	Client   *spotify.Client
-	Status   status
-	Cooldown time.Duration
-	Name     string
+	Client    *spotify.Client
+	Status    status
+	Cooldown  time.Duration
+	ColdUntil time.Time
+	Name      string
+	Stats     *RequestStats
+
+	mu               sync.Mutex
+	clientId         string
+	http             *http.Client
+	maxRetryDuration time.Duration
+	limiter          *limitedTransport
+	concurrency      int
+	markets          []string
+	includeAppearsOn bool
+	analysisLimiter  *rate.Limiter
 }
 
 type status int
@@ -42,57 +59,150 @@ func (s status) String() string {
 func NewClient(conf config.Spotify) []*Client {
 	ctx := context.Background()
 	clients := []*Client{}
-	for _, keys := range conf.Clients {
-		config := &clientcredentials.Config{
-			ClientID:     keys.ClientId,
-			ClientSecret: keys.ClientSecret,
-			TokenURL:     spotifyauth.TokenURL,
-		}
-		token, err := config.Token(ctx)
-		helper.MaybeDie(err, "could not get token")
-
-		httpClient := spotifyauth.New().Client(ctx, token)
-		client := spotify.New(
-			httpClient,
-			spotify.WithRetry(true),
-			spotify.WithMaxRetryDuration(conf.MaxRetryDuration),
-		)
-		c := Client{
-			Client: client,
-			Name:   keys.Name,
-		}
-		err = c.UpdateStatusAuto(ctx)
+	for _, key := range conf.Clients {
+		c, err := NewKeyClient(ctx, conf, key)
 		helper.MaybeDieErr(err)
-
-		clients = append(clients, &c)
+		clients = append(clients, c)
 	}
 	slog.Info("Loaded Spotify api keys", "count", len(clients))
 	return clients
 }
 
+// NewKeyClient creates the client of a single api key and probes its status.
+func NewKeyClient(ctx context.Context, conf config.Spotify, key config.SpotifyClient) (*Client, error) {
+	config := &clientcredentials.Config{
+		ClientID:     key.ClientId,
+		ClientSecret: key.ClientSecret,
+		TokenURL:     spotifyauth.TokenURL,
+	}
+	token, err := config.Token(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not get token for %q: %w", key.Name, err)
+	}
+
+	httpClient := spotifyauth.New().Client(ctx, token)
+	stats := NewRequestStats()
+	httpClient.Transport = &statsTransport{base: httpClient.Transport, stats: stats}
+	var limiter *limitedTransport
+	if conf.RateLimit > 0 {
+		limiter = newLimitedTransport(httpClient.Transport, key.Name, conf.RateLimit)
+		httpClient.Transport = limiter
+	}
+	httpClient.Transport = &traceTransport{base: httpClient.Transport}
+	c := Client{
+		Name:             key.Name,
+		Stats:            stats,
+		clientId:         key.ClientId,
+		http:             httpClient,
+		limiter:          limiter,
+		concurrency:      conf.Concurrency,
+		markets:          conf.Markets,
+		includeAppearsOn: conf.IncludeAppearsOn,
+	}
+	c.SetMaxRetryDuration(conf.MaxRetryDuration)
+	if conf.AudioAnalysis.Enabled {
+		perMinute := conf.AudioAnalysis.RequestsPerMinute
+		c.analysisLimiter = rate.NewLimiter(rate.Limit(float64(perMinute)/60), perMinute)
+	}
+	err = c.Probe(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (c *Client) ClientId() string {
+	return c.clientId
+}
+
+// SetMaxRetryDuration replaces the Spotify client with one using d, requests
+// that are already running keep the old value.
+func (c *Client) SetMaxRetryDuration(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.Client != nil && c.maxRetryDuration == d {
+		return
+	}
+	c.maxRetryDuration = d
+	c.Client = spotify.New(
+		c.http,
+		spotify.WithRetry(true),
+		spotify.WithMaxRetryDuration(d),
+	)
+}
+
+func (c *Client) api() *spotify.Client {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Client
+}
+
 func (c *Client) UpdateStatusAuto(ctx context.Context) error {
-	_, err := c.Client.GetTrack(ctx, "0VjIjW4GlUZAMYd2vXMi3b")
+	_, err := c.api().GetTrack(ctx, "0VjIjW4GlUZAMYd2vXMi3b")
 	var maxErr *spotify.MaxRetryDurationExceededErr
 	if err != nil {
 		if errors.As(err, &maxErr) {
-			c.Cooldown = maxErr.RetryAfter
-			c.Status = Cold
+			c.UpdateStatus(maxErr)
 			return nil
 		}
 		return err
 	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.Status = Available
 	return nil
 }
 
+const (
+	// probeTimeout bounds a probe, Spotify can ask to wait up to
+	// MaxRetryDuration before answering it
+	probeTimeout = 10 * time.Second
+	// probeBackoff is how long a key stays cold after a probe timed out
+	probeBackoff = time.Minute
+)
+
+// Probe updates the status like UpdateStatusAuto, but gives up after
+// probeTimeout and keeps the key cold for probeBackoff, so a rate limited key
+// does not block the caller.
+func (c *Client) Probe(ctx context.Context) error {
+	probeCtx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	err := c.UpdateStatusAuto(probeCtx)
+	if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+		slog.Info("Key is still rate limited", "name", c.Name, "retry_in", probeBackoff)
+		c.UpdateStatus(&spotify.MaxRetryDurationExceededErr{RetryAfter: probeBackoff})
+		return nil
+	}
+	return err
+}
+
 func (c *Client) UpdateStatus(err *spotify.MaxRetryDurationExceededErr) {
 	if err == nil {
 		return
 	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.Cooldown = err.RetryAfter
+	c.ColdUntil = time.Now().Add(err.RetryAfter)
 	c.Status = Cold
 }
 
+func (c *Client) IsAvailable() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Status == Available
+}
+
+// CooldownRemaining returns how long the key stays cold, 0 if it is available.
+func (c *Client) CooldownRemaining() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.Status != Cold {
+		return 0
+	}
+	return max(time.Until(c.ColdUntil), 0)
+}
+
 func GetArtists(fs []*FullerTrack, mainArtist spotify.ID) []spotify.ID {
 	seen := make(map[string]struct{})
 	out := []spotify.ID{}
@@ -126,19 +236,94 @@ func getAllArtists(fs *[]spotify.SimpleTrack) []spotify.ID {
 	return out
 }
 
-func (c *Client) FetchArtistTracks(ctx context.Context, id spotify.ID) ([]*FullerTrack, int, error) {
-	requestCount := 0
-	albums, err := c.Client.GetArtistAlbums(
-		ctx,
+// Index keeps track of the albums and tracks that have already been fetched
+// so shared albums and tracks are only fetched once per crawl. A job claims
+// ids before fetching them and commits them once they are stored, claims
+// that are neither committed nor released are released when the job is
+// recovered.
+type Index interface {
+	ClaimAlbums(ctx context.Context, job string, ids []spotify.ID) ([]spotify.ID, error)
+	ReleaseAlbums(ctx context.Context, job string, ids []spotify.ID) error
+	CommitAlbums(ctx context.Context, job string, ids []spotify.ID) error
+	ClaimTracks(ctx context.Context, job string, ids []spotify.ID) ([]spotify.ID, error)
+	ReleaseTracks(ctx context.Context, job string, ids []spotify.ID) error
+	CommitTracks(ctx context.Context, job string, ids []spotify.ID) error
+}
+
+func (c *Client) albumTypes() []spotify.AlbumType {
+	ts := []spotify.AlbumType{
+		spotify.AlbumTypeAlbum,
+		spotify.AlbumTypeSingle,
+		spotify.AlbumTypeCompilation,
+	}
+	if c.includeAppearsOn {
+		ts = append(ts, spotify.AlbumTypeAppearsOn)
+	}
+	return ts
+}
+
+// filterClaimed claims the items in the index and drops the ones that were
+// already claimed by an earlier fetch.
+func filterClaimed[T any](
+	ctx context.Context,
+	job string,
+	items []T,
+	id func(T) spotify.ID,
+	claim func(context.Context, string, []spotify.ID) ([]spotify.ID, error),
+) ([]T, []spotify.ID, error) {
+	ids := make([]spotify.ID, len(items))
+	for i, item := range items {
+		ids[i] = id(item)
+	}
+	claimed, err := claim(ctx, job, ids)
+	if err != nil {
+		return nil, nil, err
+	}
+	keep := make(map[spotify.ID]struct{}, len(claimed))
+	for _, id := range claimed {
+		keep[id] = struct{}{}
+	}
+
+	out := []T{}
+	for _, item := range items {
+		if _, ok := keep[id(item)]; ok {
+			out = append(out, item)
+			// a track can appear more than once, only keep the first
+			delete(keep, id(item))
+		}
+	}
+	return out, claimed, nil
+}
+
+func releaseClaimed(release func(context.Context, string, []spotify.ID) error, job string, ids []spotify.ID) {
+	if len(ids) == 0 {
+		return
+	}
+	err := release(context.Background(), job, ids)
+	if err != nil {
+		slog.Warn("failed to release claims", "error", err)
+	}
+}
+
+// StreamArtistTracks fetches all tracks of an artist and calls yield with
+// every batch of at most 100 tracks as soon as it is complete. yield is never
+// called concurrently, an error returned by it stops the fetch. The tracks of
+// a batch are committed to idx once yield accepted it, the albums once all
+// batches are. When the fetch fails the remaining claims are released, so a
+// retry only fetches what is missing.
+func (c *Client) StreamArtistTracks(ctx context.Context, id spotify.ID, idx Index, yield func([]*FullerTrack) error) (requestCount int, err error) {
+	// keep using the same client for the whole job, even when it is replaced
+	api := c.api()
+	cctx, cl := startCall(ctx, "GetArtistAlbums", 50)
+	albums, err := api.GetArtistAlbums(
+		cctx,
 		id,
-		[]spotify.AlbumType{
-			spotify.AlbumTypeAlbum,
-			spotify.AlbumTypeSingle,
-			spotify.AlbumTypeCompilation,
-			// spotify.AlbumTypeAppearsOn,
-		}, spotify.Limit(50))
+		c.albumTypes(),
+		spotify.Limit(50),
+	)
+	cl.end(err)
 	if err != nil {
-		return nil, requestCount, err
+		return requestCount, err
 	}
 	requestCount++
 
@@ -146,90 +331,183 @@ func (c *Client) FetchArtistTracks(ctx context.Context, id spotify.ID) ([]*Fulle
 	// cap to prevent infinite loop
 	for range 100 {
 		allAlbums = append(allAlbums, albums.Albums...)
-		err = c.Client.NextPage(ctx, albums)
-		if err == spotify.ErrNoMorePages {
+		if albums.Next == "" {
 			break
 		}
+		cctx, cl := startCall(ctx, "NextPage", 50)
+		err = api.NextPage(cctx, albums)
+		cl.end(err)
 		if err != nil {
-			return nil, requestCount, err
+			return requestCount, err
 		}
 		requestCount++
 	}
 
-	allSimpleTracks := []spotify.SimpleTrack{}
-	for chunk := range slices.Chunk(allAlbums, 20) {
+	var claimedAlbums, claimedTracks []spotify.ID
+	yielded := make(map[spotify.ID]struct{})
+	var yieldMu sync.Mutex
+	if idx != nil {
+		allAlbums, claimedAlbums, err = filterClaimed(ctx, id.String(), allAlbums, func(a spotify.SimpleAlbum) spotify.ID { return a.ID }, idx.ClaimAlbums)
+		if err != nil {
+			return requestCount, err
+		}
+		defer func() {
+			// let a later job fetch what was not stored
+			if err != nil {
+				releaseClaimed(idx.ReleaseAlbums, id.String(), claimedAlbums)
+				releaseClaimed(idx.ReleaseTracks, id.String(), slices.DeleteFunc(claimedTracks, func(id spotify.ID) bool {
+					_, ok := yielded[id]
+					return ok
+				}))
+				return
+			}
+			err = idx.CommitAlbums(context.Background(), id.String(), claimedAlbums)
+			if err != nil {
+				// the tracks are committed, a later job only fetches the albums
+				releaseClaimed(idx.ReleaseAlbums, id.String(), claimedAlbums)
+			}
+		}()
+	}
+
+	chunkTracks := make([][]spotify.SimpleTrack, (len(allAlbums)+19)/20)
+	chunkMeta := make([]map[spotify.ID]*AlbumMeta, len(chunkTracks))
+	n, err := fanOut(ctx, c.concurrency, allAlbums, 20, func(ctx context.Context, i int, chunk []spotify.SimpleAlbum) (int, error) {
+		requestCount := 0
 		ids := make([]spotify.ID, len(chunk))
-		for i, a := range chunk {
-			ids[i] = a.ID
+		for j, a := range chunk {
+			ids[j] = a.ID
 		}
-		fullAlbums, err := c.Client.GetAlbums(ctx, ids)
+		cctx, cl := startCall(ctx, "GetAlbums", len(ids))
+		fullAlbums, err := api.GetAlbums(cctx, ids, c.marketOpts()...)
+		cl.end(err)
 		if err != nil {
-			return nil, requestCount, err
+			return requestCount, err
 		}
 		requestCount++
+		chunkMeta[i] = make(map[spotify.ID]*AlbumMeta)
 		for _, fullAlbum := range fullAlbums {
+			meta := NewAlbumMeta(fullAlbum)
 			tracks := fullAlbum.Tracks
 			for range 100 {
-				allSimpleTracks = append(allSimpleTracks, tracks.Tracks...)
-				err = c.Client.NextPage(ctx, &tracks)
-				if err == spotify.ErrNoMorePages {
+				chunkTracks[i] = append(chunkTracks[i], tracks.Tracks...)
+				for _, t := range tracks.Tracks {
+					chunkMeta[i][t.ID] = meta
+				}
+				if tracks.Next == "" {
 					break
 				}
+				cctx, cl := startCall(ctx, "NextPage", 50)
+				err = api.NextPage(cctx, &tracks)
+				cl.end(err)
 				if err != nil {
-					return nil, 0, err
+					return requestCount, err
 				}
 				requestCount++
 			}
 		}
+		return requestCount, nil
+	})
+	requestCount += n
+	if err != nil {
+		return requestCount, err
+	}
+	allSimpleTracks := slices.Concat(chunkTracks...)
+	// keyed by the requested track id, a track relinked for the market can
+	// belong to another album than the one it was found on
+	albumMeta := make(map[spotify.ID]*AlbumMeta)
+	for _, metas := range chunkMeta {
+		maps.Copy(albumMeta, metas)
+	}
+
+	if idx != nil {
+		allSimpleTracks, claimedTracks, err = filterClaimed(ctx, id.String(), allSimpleTracks, func(t spotify.SimpleTrack) spotify.ID { return t.ID }, idx.ClaimTracks)
+		if err != nil {
+			return requestCount, err
+		}
 	}
 
 	artistIds := getAllArtists(&allSimpleTracks)
-	allArtists := make(map[spotify.ID]*spotify.FullArtist)
-	for chunk := range slices.Chunk(artistIds, 50) {
-		r, err := c.Client.GetArtists(ctx, chunk...)
+	chunkArtists := make([][]*spotify.FullArtist, (len(artistIds)+49)/50)
+	n, err = fanOut(ctx, c.concurrency, artistIds, 50, func(ctx context.Context, i int, chunk []spotify.ID) (int, error) {
+		cctx, cl := startCall(ctx, "GetArtists", len(chunk))
+		r, err := api.GetArtists(cctx, chunk...)
+		cl.end(err)
 		if err != nil {
-			return nil, requestCount, err
+			return 0, err
 		}
-		requestCount++
+		chunkArtists[i] = r
+		return 1, nil
+	})
+	requestCount += n
+	if err != nil {
+		return requestCount, err
+	}
+	allArtists := make(map[spotify.ID]*spotify.FullArtist)
+	for _, r := range chunkArtists {
 		for _, a := range r {
 			allArtists[a.ID] = a
 		}
 	}
 
-	allTracks := make([]*FullerTrack, len(allSimpleTracks))
-	offset := 0
-	for chunk := range slices.Chunk(allSimpleTracks, 100) {
+	n, err = fanOut(ctx, c.concurrency, allSimpleTracks, 100, func(ctx context.Context, _ int, chunk []spotify.SimpleTrack) (int, error) {
+		requestCount := 0
 		ids := make([]spotify.ID, len(chunk))
-		for i, a := range chunk {
-			ids[i] = a.ID
+		for j, a := range chunk {
+			ids[j] = a.ID
 		}
 
-		features, err := c.Client.GetAudioFeatures(ctx, ids...)
+		cctx, cl := startCall(ctx, "GetAudioFeatures", len(ids))
+		features, err := api.GetAudioFeatures(cctx, ids...)
+		cl.end(err)
 		if err != nil {
-			return nil, requestCount, err
+			return requestCount, err
 		}
 		requestCount++
 
 		fullTracks := []*spotify.FullTrack{}
 		for subChunk := range slices.Chunk(ids, 50) {
-			full, err := c.Client.GetTracks(ctx, subChunk, spotify.Limit(50))
+			cctx, cl := startCall(ctx, "GetTracks", len(subChunk))
+			full, err := api.GetTracks(cctx, subChunk, c.marketOpts(spotify.Limit(50))...)
+			cl.end(err)
 			if err != nil {
-				return nil, 0, err
+				return requestCount, err
 			}
 			requestCount++
 			fullTracks = append(fullTracks, full...)
 		}
-		for i := range len(ids) {
+		playable, playableCount, err := c.playability(ctx, ids, fullTracks)
+		requestCount += playableCount
+		if err != nil {
+			return requestCount, err
+		}
+		batch := make([]*FullerTrack, len(ids))
+		for j := range len(ids) {
 			ft := &FullerTrack{
-				Track:    fullTracks[i],
-				Features: features[i],
+				Track:    fullTracks[j],
+				Features: features[j],
+				Album:    albumMeta[ids[j]],
+				Playable: playable[j],
 			}
 			for _, a := range ft.Track.Artists {
 				ft.Artists = append(ft.Artists, allArtists[a.ID])
 			}
-			allTracks[i+offset] = ft
+			batch[j] = ft
 		}
-		offset += 100
-	}
-	return allTracks, requestCount, nil
+
+		yieldMu.Lock()
+		defer yieldMu.Unlock()
+		err = yield(batch)
+		if err != nil {
+			return requestCount, err
+		}
+		for _, id := range ids {
+			yielded[id] = struct{}{}
+		}
+		if idx != nil {
+			err = idx.CommitTracks(context.Background(), id.String(), ids)
+		}
+		return requestCount, err
+	})
+	requestCount += n
+	return requestCount, err
 }
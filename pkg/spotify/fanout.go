@@ -0,0 +1,34 @@
+package spotify
+
+import (
+	"context"
+	"slices"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// fanOut calls fn for every chunk of items with at most limit calls running
+// at the same time. fn gets the index of its chunk and returns the number of
+// requests it made, fanOut returns the total. The first error cancels the
+// context of the other calls.
+func fanOut[T any](ctx context.Context, limit int, items []T, size int, fn func(ctx context.Context, i int, chunk []T) (int, error)) (int, error) {
+	chunks := slices.Collect(slices.Chunk(items, size))
+	counts := make([]int, len(chunks))
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(max(limit, 1))
+	for i, chunk := range chunks {
+		g.Go(func() error {
+			n, err := fn(gctx, i, chunk)
+			counts[i] = n
+			return err
+		})
+	}
+	err := g.Wait()
+
+	total := 0
+	for _, n := range counts {
+		total += n
+	}
+	return total, err
+}
@@ -0,0 +1,53 @@
+package spotify
+
+import (
+	"context"
+	"slices"
+
+	"github.com/zmb3/spotify/v2"
+)
+
+// marketOpts adds the primary market to opts, if one is configured.
+func (c *Client) marketOpts(opts ...spotify.RequestOption) []spotify.RequestOption {
+	if len(c.markets) == 0 {
+		return opts
+	}
+	return append(opts, spotify.Market(c.markets[0]))
+}
+
+// playability returns for each track whether it is playable in each
+// configured market. The primary market is read from tracks, which must be
+// fetched with marketOpts, the other markets cost one extra request per 50
+// tracks each.
+func (c *Client) playability(ctx context.Context, ids []spotify.ID, tracks []*spotify.FullTrack) ([]map[string]bool, int, error) {
+	requestCount := 0
+	out := make([]map[string]bool, len(ids))
+	if len(c.markets) == 0 {
+		return out, requestCount, nil
+	}
+
+	for i, t := range tracks {
+		out[i] = map[string]bool{c.markets[0]: isPlayable(t)}
+	}
+	for _, market := range c.markets[1:] {
+		offset := 0
+		for subChunk := range slices.Chunk(ids, 50) {
+			cctx, cl := startCall(ctx, "GetTracks", len(subChunk))
+			full, err := c.api().GetTracks(cctx, subChunk, spotify.Limit(50), spotify.Market(market))
+			cl.end(err)
+			if err != nil {
+				return nil, requestCount, err
+			}
+			requestCount++
+			for i, t := range full {
+				out[i+offset][market] = isPlayable(t)
+			}
+			offset += 50
+		}
+	}
+	return out, requestCount, nil
+}
+
+func isPlayable(t *spotify.FullTrack) bool {
+	return t != nil && t.IsPlayable != nil && *t.IsPlayable
+}
@@ -0,0 +1,100 @@
+package spotify
+
+import (
+	"maps"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EndpointStats are the request counters of a single endpoint.
+type EndpointStats struct {
+	Requests int64
+	Latency  time.Duration
+	Status   map[int]int64
+	// Time Spotify told us to wait before retrying
+	RetryWait time.Duration
+}
+
+// RequestStats counts the requests of one key per endpoint.
+type RequestStats struct {
+	mu        sync.Mutex
+	endpoints map[string]*EndpointStats
+}
+
+func NewRequestStats() *RequestStats {
+	return &RequestStats{endpoints: make(map[string]*EndpointStats)}
+}
+
+func (s *RequestStats) record(endpoint string, status int, latency time.Duration, retryWait time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.endpoints[endpoint]
+	if !ok {
+		e = &EndpointStats{Status: make(map[int]int64)}
+		s.endpoints[endpoint] = e
+	}
+	e.Requests++
+	e.Latency += latency
+	e.Status[status]++
+	e.RetryWait += retryWait
+}
+
+// Swap returns the counters per endpoint and resets them.
+func (s *RequestStats) Swap() map[string]EndpointStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[string]EndpointStats, len(s.endpoints))
+	for name, e := range s.endpoints {
+		out[name] = EndpointStats{
+			Requests:  e.Requests,
+			Latency:   e.Latency,
+			Status:    maps.Clone(e.Status),
+			RetryWait: e.RetryWait,
+		}
+	}
+	s.endpoints = make(map[string]*EndpointStats)
+	return out
+}
+
+var idSegment = regexp.MustCompile(`^[0-9A-Za-z]{22}$`)
+
+// endpoint turns a request path like /v1/artists/<id>/albums into
+// artists/{id}/albums.
+func endpoint(path string) string {
+	parts := strings.Split(strings.TrimPrefix(path, "/v1/"), "/")
+	for i, p := range parts {
+		if idSegment.MatchString(p) {
+			parts[i] = "{id}"
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// statsTransport records every request that reaches Spotify.
+type statsTransport struct {
+	base  http.RoundTripper
+	stats *RequestStats
+}
+
+func (t *statsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	latency := time.Since(start)
+	if err != nil {
+		t.stats.record(endpoint(req.URL.Path), 0, latency, 0)
+		return nil, err
+	}
+	var retryWait time.Duration
+	if resp.StatusCode == http.StatusTooManyRequests {
+		seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+		if err == nil {
+			retryWait = time.Duration(seconds) * time.Second
+		}
+	}
+	t.stats.record(endpoint(req.URL.Path), resp.StatusCode, latency, retryWait)
+	return resp, nil
+}
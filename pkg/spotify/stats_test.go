@@ -0,0 +1,28 @@
+package spotify
+
+import "testing"
+
+func TestEndpoint(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/v1/tracks", "tracks"},
+		{"/v1/artists/0TnOYISbd1XYRBk9myaseg", "artists/{id}"},
+		{"/v1/artists/0TnOYISbd1XYRBk9myaseg/albums", "artists/{id}/albums"},
+		{"/v1/albums/4aawyAB9vmqN3uQ7FjRGTy/tracks", "albums/{id}/tracks"},
+		{"/v1/audio-analysis/0VjIjW4GlUZAMYd2vXMi3b", "audio-analysis/{id}"},
+		// not 22 characters, so not an id
+		{"/v1/browse/categories", "browse/categories"},
+		{"/v1/artists/short", "artists/short"},
+		{"/api/token", "/api/token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got := endpoint(tt.path)
+			if got != tt.want {
+				t.Errorf("endpoint(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package spotify
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/Pineapple217/MetaRaid/pkg/spotify")
+
+// call is the span of a single API call, the Spotify client can make several
+// attempts for it when it is rate limited.
+type call struct {
+	span     trace.Span
+	attempts atomic.Int64
+}
+
+type callKey struct{}
+
+// startCall starts the span of an API call, pass the returned context to
+// the call so traceTransport can record its attempts.
+func startCall(ctx context.Context, name string, batchSize int) (context.Context, *call) {
+	c := &call{}
+	ctx, c.span = tracer.Start(ctx, "spotify."+name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.Int("spotify.batch_size", batchSize)),
+	)
+	return context.WithValue(ctx, callKey{}, c), c
+}
+
+func (c *call) end(err error) {
+	attempts := c.attempts.Load()
+	c.span.SetAttributes(
+		attribute.Int64("spotify.attempts", attempts),
+		attribute.Int64("spotify.retries", max(attempts-1, 0)),
+	)
+	if err != nil {
+		c.span.RecordError(err)
+		c.span.SetStatus(codes.Error, err.Error())
+	}
+	c.span.End()
+}
+
+// traceTransport adds every attempt of an API call to its span.
+type traceTransport struct {
+	base http.RoundTripper
+}
+
+func (t *traceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c, ok := req.Context().Value(callKey{}).(*call)
+	if !ok {
+		return t.base.RoundTrip(req)
+	}
+	attempt := c.attempts.Add(1)
+	c.span.SetAttributes(attribute.String("spotify.endpoint", endpoint(req.URL.Path)))
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		c.span.AddEvent("attempt", trace.WithAttributes(
+			attribute.Int64("attempt", attempt),
+			attribute.String("error", err.Error()),
+		))
+		return nil, err
+	}
+	c.span.AddEvent("attempt", trace.WithAttributes(
+		attribute.Int64("attempt", attempt),
+		attribute.Int("http.status_code", resp.StatusCode),
+		attribute.String("retry_after", resp.Header.Get("Retry-After")),
+	))
+	return resp, nil
+}
@@ -9,9 +9,45 @@ type FullerTrack struct {
 	Track    *spotify.FullTrack
 	Features *spotify.AudioFeatures
 	Artists  []*spotify.FullArtist
+	Album    *AlbumMeta
+	Analysis *AnalysisSummary
+	// Playability per configured market, nil if no markets are configured
+	Playable map[string]bool
+}
+
+// AlbumMeta holds the album fields that are only present on a FullAlbum
+// and not on the SimpleAlbum embedded in a FullTrack.
+type AlbumMeta struct {
+	Label       string
+	Copyrights  []spotify.Copyright
+	ExternalIDs map[string]string
+}
+
+func NewAlbumMeta(a *spotify.FullAlbum) *AlbumMeta {
+	return &AlbumMeta{
+		Label:       a.Label,
+		Copyrights:  a.Copyrights,
+		ExternalIDs: a.ExternalIDs,
+	}
+}
+
+// Copyright returns the copyright text of the given type ("C" or "P").
+func (a *AlbumMeta) Copyright(t string) string {
+	for _, c := range a.Copyrights {
+		if c.Type == t {
+			return c.Text
+		}
+	}
+	return ""
 }
 
 func (f *FullerTrack) Serialize() ([]byte, error) {
 	b, err := msgpack.Marshal(f)
 	return b, err
 }
+
+func DeserializeFullerTrack(b []byte) (*FullerTrack, error) {
+	var f FullerTrack
+	err := msgpack.Unmarshal(b, &f)
+	return &f, err
+}
@@ -0,0 +1,72 @@
+package spotify
+
+import (
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Spotify calculates its rate limit over a rolling 30 second window.
+const rateWindow = 30 * time.Second
+
+// limitedTransport waits on a token bucket before every request. When Spotify
+// answers with 429 the rate is halved, every successful request then slowly
+// raises it back to the configured maximum.
+type limitedTransport struct {
+	base    http.RoundTripper
+	limiter *rate.Limiter
+	name    string
+
+	mu  sync.Mutex
+	max rate.Limit
+	min rate.Limit
+}
+
+func newLimitedTransport(base http.RoundTripper, name string, perWindow int) *limitedTransport {
+	max := rate.Limit(float64(perWindow) / rateWindow.Seconds())
+	return &limitedTransport{
+		base:    base,
+		limiter: rate.NewLimiter(max, min(perWindow, 10)),
+		name:    name,
+		max:     max,
+		min:     max / 20,
+	}
+}
+
+func (t *limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	err := t.limiter.Wait(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		t.slowDown()
+	} else {
+		t.speedUp()
+	}
+	return resp, nil
+}
+
+func (t *limitedTransport) slowDown() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	limit := max(t.limiter.Limit()/2, t.min)
+	t.limiter.SetLimit(limit)
+	slog.Warn("rate limited, slowing down", "client", t.name, "per_window", float64(limit)*rateWindow.Seconds())
+}
+
+func (t *limitedTransport) speedUp() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	limit := t.limiter.Limit()
+	if limit >= t.max {
+		return
+	}
+	t.limiter.SetLimit(min(limit+t.max/100, t.max))
+}
@@ -0,0 +1,43 @@
+package spotify
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/time/rate"
+)
+
+func TestLimitedTransportRate(t *testing.T) {
+	// 90 requests per 30 seconds is a max of 3 per second
+	tests := []struct {
+		name  string
+		start rate.Limit
+		steps string // s for slowDown, u for speedUp
+		want  rate.Limit
+	}{
+		{"slow down halves", 3, "s", 1.5},
+		{"slow down twice", 3, "ss", 0.75},
+		{"slow down stops at min", 3, "ssssssss", 0.15},
+		{"speed up adds a percent", 1.5, "u", 1.53},
+		{"speed up stops at max", 2.99, "uu", 3},
+		{"speed up at max", 3, "u", 3},
+		{"recovers after slowing down", 3, "s" + strings.Repeat("u", 50), 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := newLimitedTransport(nil, "test", 90)
+			tr.limiter.SetLimit(tt.start)
+			for _, step := range tt.steps {
+				if step == 's' {
+					tr.slowDown()
+				} else {
+					tr.speedUp()
+				}
+			}
+			got := tr.limiter.Limit()
+			if diff := got - tt.want; diff > 1e-9 || diff < -1e-9 {
+				t.Errorf("limit = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}